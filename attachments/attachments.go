@@ -0,0 +1,159 @@
+package attachments
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/DHCPCD9/go-swaga-bot/configuration"
+	"github.com/DHCPCD9/go-swaga-bot/database"
+	log "github.com/sirupsen/logrus"
+)
+
+// Enabled reports whether attachments are kept locally
+func Enabled() bool {
+	return configuration.Config.Attachments.CacheDir != ""
+}
+
+// IsText reports whether the content of a file is worth keeping as text
+func IsText(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return strings.HasPrefix(mediaType, "text/") ||
+		mediaType == "application/json" ||
+		mediaType == "application/xml" ||
+		mediaType == "application/x-yaml" ||
+		mediaType == "application/yaml"
+}
+
+// Process downloads an indexed attachment if there is a reason to, then hashes
+// it, stores it in the cache and extracts its text
+func Process(ctx context.Context, attachment *database.IndexedAttachments) error {
+	config := configuration.Config.Attachments
+
+	extract := config.ExtractText && IsText(attachment.ContentType)
+	if !Enabled() && !extract {
+		return nil
+	}
+	if config.MaxFileSize > 0 && attachment.Size > config.MaxFileSize {
+		log.Debugf("Attachment %s is too big to download (%d bytes)", attachment.Filename, attachment.Size)
+		return nil
+	}
+
+	data, err := Download(ctx, attachment.URL, config.MaxFileSize)
+	if err != nil {
+		return err
+	}
+
+	attachment.Hash = Hash(data)
+	if Enabled() {
+		if attachment.LocalPath, err = Save(attachment.Hash, data); err != nil {
+			return err
+		}
+	}
+	if extract {
+		attachment.Content = ExtractText(data, config.MaxTextLength)
+	}
+
+	return database.UpdateAttachment(attachment)
+}
+
+// Download fetches a file, refusing anything bigger than maxSize bytes when it is positive
+func Download(ctx context.Context, url string, maxSize int64) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download of %s failed with status code %d", url, response.StatusCode)
+	}
+
+	reader := io.Reader(response.Body)
+	if maxSize > 0 {
+		reader = io.LimitReader(response.Body, maxSize+1)
+	}
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if maxSize > 0 && int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("file %s is bigger than %d bytes", url, maxSize)
+	}
+
+	return data, nil
+}
+
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Save stores a blob under its hash, so identical files are kept once
+func Save(hash string, data []byte) (string, error) {
+	dir := filepath.Join(configuration.Config.Attachments.CacheDir, hash[:2])
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, hash)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	//Written next to the target and renamed, so a crash never leaves half a file
+	temporary := path + ".tmp"
+	if err := os.WriteFile(temporary, data, 0644); err != nil {
+		return "", err
+	}
+	return path, os.Rename(temporary, path)
+}
+
+// Load reads a cached attachment
+func Load(attachment database.IndexedAttachments) ([]byte, error) {
+	if attachment.LocalPath == "" {
+		return nil, fmt.Errorf("attachment %s is not cached", attachment.Filename)
+	}
+	return os.ReadFile(attachment.LocalPath)
+}
+
+// Remove deletes cached blobs, missing ones are fine
+func Remove(paths []string) {
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Errorf("Failed to remove cached attachment %s: %v", path, err)
+		}
+	}
+}
+
+// ExtractText returns data as text cut to maxLength runes, nothing if it isn't valid UTF-8
+func ExtractText(data []byte, maxLength int) string {
+	if !utf8.Valid(data) {
+		return ""
+	}
+
+	text := string(data)
+	if maxLength > 0 && utf8.RuneCountInString(text) > maxLength {
+		text = string([]rune(text)[:maxLength]) + "\n[...]"
+	}
+	return text
+}
+
+// Describe renders attachment metadata for the prompt
+func Describe(attachment database.IndexedAttachments) string {
+	return fmt.Sprintf("[attachment %s: %s, %s, %d bytes]", attachment.AttachmentID, attachment.Filename, attachment.ContentType, attachment.Size)
+}
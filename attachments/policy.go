@@ -0,0 +1,83 @@
+package attachments
+
+import (
+	"errors"
+	"mime"
+	"strings"
+
+	"github.com/DHCPCD9/go-swaga-bot/configuration"
+	"github.com/DHCPCD9/go-swaga-bot/llm"
+)
+
+// Mode says how an attachment reaches the model
+type Mode int
+
+const (
+	ModeInline Mode = iota // data is sent within the request
+	ModeUpload             // uploaded to the provider first, the request only references it
+	ModeText               // the model can't take the file, its text is sent instead
+)
+
+var (
+	ErrUnsupportedType = errors.New("attachment type is not supported")
+	ErrTooLarge        = errors.New("attachment is too large")
+)
+
+// MediaType strips parameters like charset from a content type
+func MediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return mediaType
+}
+
+// TypeAllowed matches a media type against patterns like "image/*" or "application/pdf",
+// no patterns allow everything
+func TypeAllowed(mediaType string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, pattern := range patterns {
+		if pattern == mediaType || strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check decides how a file of contentType and size bytes is shown to provider,
+// the error tells why it can't be shown at all
+func Check(provider llm.Provider, contentType string, size int64) (Mode, error) {
+	config := configuration.Config.Attachments
+	mediaType := MediaType(contentType)
+
+	if !TypeAllowed(mediaType, config.AllowedTypes) {
+		return 0, ErrUnsupportedType
+	}
+	if config.MaxUploadSize > 0 && size > config.MaxUploadSize {
+		return 0, ErrTooLarge
+	}
+
+	if !llm.AcceptsMedia(provider, mediaType) {
+		if IsText(contentType) {
+			return ModeText, nil
+		}
+		return 0, ErrUnsupportedType
+	}
+
+	if config.MaxInlineSize <= 0 || size <= config.MaxInlineSize {
+		return ModeInline, nil
+	}
+	if _, ok := provider.(llm.FileUploader); !ok || !Uploadable(mediaType) {
+		return 0, ErrTooLarge
+	}
+	return ModeUpload, nil
+}
+
+// Uploadable reports whether files of mediaType may go through a provider file
+// upload when they are too big to be inline: video, audio and PDF
+func Uploadable(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "video/") || strings.HasPrefix(mediaType, "audio/") || mediaType == "application/pdf"
+}
@@ -3,6 +3,7 @@ package configuration
 import (
 	_ "embed"
 	"os"
+	"time"
 
 	"github.com/goccy/go-yaml"
 	"github.com/sirupsen/logrus"
@@ -12,17 +13,148 @@ import (
 var DEFAULT_CONFIG string
 
 type GlobalConfiguration struct {
-	Discord  Discord  `yaml:"discord"`
-	Gemini   Gemini   `yaml:"gemini"`
-	Database Database `yaml:"database"`
+	Discord     Discord     `yaml:"discord"`
+	LLM         LLM         `yaml:"llm"`
+	Gemini      Gemini      `yaml:"gemini"`
+	OpenAI      OpenAI      `yaml:"openai"`
+	Context     Context     `yaml:"context"`
+	Attachments Attachments `yaml:"attachments"`
+	Limits      Limits      `yaml:"limits"`
+	Embeddings  Embeddings  `yaml:"embeddings"`
+	Memory      Memory      `yaml:"memory"`
+	Database    Database    `yaml:"database"`
 }
 type Discord struct {
-	Token            string `yaml:"token"`
-	IndexAllChannels bool   `yaml:"index-all-channels"`
+	Token            string                   `yaml:"token"`
+	IndexAllChannels bool                     `yaml:"index-all-channels"`
+	DirectMessages   bool                     `yaml:"direct-messages"`
+	Owners           []string                 `yaml:"owners"`
+	Channels         map[string]GuildChannels `yaml:"channels"`
+	Responses        Responses                `yaml:"responses"`
+}
+type Responses struct {
+	UseEmbeds   bool `yaml:"use-embeds"`
+	MaxMessages int  `yaml:"max-messages"`
+}
+type GuildChannels struct {
+	Index ChannelRules `yaml:"index"`
+	Reply ChannelRules `yaml:"reply"`
+}
+
+// ChannelRules hold channel or category IDs
+type ChannelRules struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+type LLM struct {
+	Provider     string           `yaml:"provider"`
+	Timeout      time.Duration    `yaml:"timeout"`
+	Retry        Retry            `yaml:"retry"`
+	Prices       map[string]Price `yaml:"prices"`
+	MaxToolSteps int              `yaml:"max-tool-steps"`
+}
+
+// Price is in USD per million tokens
+type Price struct {
+	Input  float64 `yaml:"input"`
+	Output float64 `yaml:"output"`
+}
+type Retry struct {
+	MaxAttempts int           `yaml:"max-attempts"`
+	BaseDelay   time.Duration `yaml:"base-delay"`
+	MaxDelay    time.Duration `yaml:"max-delay"`
 }
 type Gemini struct {
-	Token string `yaml:"token"`
-	Model string `yaml:"model"`
+	Token          string `yaml:"token"`
+	Model          string `yaml:"model"`
+	EmbeddingModel string `yaml:"embedding-model"`
+	BaseURL        string `yaml:"base-url"`
+	APIVersion     string `yaml:"api-version"`
+}
+type OpenAI struct {
+	Token          string `yaml:"token"`
+	Model          string `yaml:"model"`
+	EmbeddingModel string `yaml:"embedding-model"`
+	BaseURL        string `yaml:"base-url"`
+}
+
+type Context struct {
+	Strategy        string `yaml:"strategy"`
+	ChannelMessages int    `yaml:"channel-messages"`
+	AuthorMessages  int    `yaml:"author-messages"`
+	ThreadDepth     int    `yaml:"thread-depth"`
+	TokenBudget     int    `yaml:"token-budget"`
+	CountTokens     bool   `yaml:"count-tokens"`
+	FactsBudget     int    `yaml:"facts-budget"`
+}
+
+type Attachments struct {
+	CacheDir           string   `yaml:"cache-dir"`
+	MaxFileSize        int64    `yaml:"max-file-size"`
+	ExtractText        bool     `yaml:"extract-text"`
+	MaxTextLength      int      `yaml:"max-text-length"`
+	HistoryAttachments int      `yaml:"history-attachments"`
+	AllowedTypes       []string `yaml:"allowed-types"`
+	MaxInlineSize      int64    `yaml:"max-inline-size"`
+	MaxUploadSize      int64    `yaml:"max-upload-size"`
+}
+
+type Limits struct {
+	User    Bucket `yaml:"user"`
+	Channel Bucket `yaml:"channel"`
+	Guild   Bucket `yaml:"guild"`
+	Quotas  `yaml:",inline"`
+	Guilds  map[string]Quotas `yaml:"guilds"`
+}
+
+// Bucket allows Rate answers per Per, up to Rate in a burst. A zero rate disables it.
+type Bucket struct {
+	Rate int           `yaml:"rate"`
+	Per  time.Duration `yaml:"per"`
+}
+
+// Quotas are daily token limits, zero means unlimited. Unset guild overrides
+// fall back to the global values.
+type Quotas struct {
+	DailyUserTokens  *int64   `yaml:"daily-user-tokens"`
+	DailyGuildTokens *int64   `yaml:"daily-guild-tokens"`
+	ExemptRoles      []string `yaml:"exempt-roles"`
+}
+
+// GuildQuotas returns the quotas of a guild with its overrides applied
+func (l Limits) GuildQuotas(guildID string) Quotas {
+	quotas := l.Quotas
+	override, ok := l.Guilds[guildID]
+	if !ok {
+		return quotas
+	}
+
+	if override.DailyUserTokens != nil {
+		quotas.DailyUserTokens = override.DailyUserTokens
+	}
+	if override.DailyGuildTokens != nil {
+		quotas.DailyGuildTokens = override.DailyGuildTokens
+	}
+	if override.ExemptRoles != nil {
+		quotas.ExemptRoles = override.ExemptRoles
+	}
+	return quotas
+}
+
+type Memory struct {
+	FactMaxAge    time.Duration `yaml:"fact-max-age"`
+	PruneInterval time.Duration `yaml:"prune-interval"`
+}
+
+type Embeddings struct {
+	Enabled         bool          `yaml:"enabled"`
+	Dimensions      int           `yaml:"dimensions"`
+	BatchSize       int           `yaml:"batch-size"`
+	Interval        time.Duration `yaml:"interval"`
+	MinLength       int           `yaml:"min-length"`
+	RelatedMessages int           `yaml:"related-messages"`
+	RelatedFacts    int           `yaml:"related-facts"`
+	MinSimilarity   float64       `yaml:"min-similarity"`
 }
 
 type Database struct {
@@ -55,6 +187,10 @@ func ReadConfig() (*GlobalConfiguration, error) {
 
 	logrus.Debug("Parsing configuration file")
 	var config GlobalConfiguration
+	//Defaults go first, so keys missing from an older config.yml keep sane values
+	if err = yaml.Unmarshal([]byte(DEFAULT_CONFIG), &config); err != nil {
+		return nil, err
+	}
 	err = yaml.Unmarshal(bytes, &config)
 	if err != nil {
 		return nil, err
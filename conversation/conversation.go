@@ -0,0 +1,424 @@
+package conversation
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/DHCPCD9/go-swaga-bot/attachments"
+	"github.com/DHCPCD9/go-swaga-bot/configuration"
+	"github.com/DHCPCD9/go-swaga-bot/database"
+	"github.com/DHCPCD9/go-swaga-bot/embeddings"
+	"github.com/DHCPCD9/go-swaga-bot/llm"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	StrategyChannel = "channel"
+	StrategyAuthor  = "author"
+	StrategyThread  = "thread"
+	StrategyMixed   = "mixed"
+)
+
+// Input describes the message the bot is answering
+type Input struct {
+	BotID      string
+	MessageID  string
+	UserID     string
+	GuildID    string
+	ChannelID  string
+	ReplyChain []database.IndexedMessages  // Messages the current one replies to, oldest first
+	Question   string                      // Text the related messages and facts are looked up for
+	Visible    func(channelID string) bool // Channels the asker can read, nil for all
+}
+
+// Fetcher loads a message missing from the database from somewhere else
+type Fetcher func(messageID string) (*database.IndexedMessages, error)
+
+// Build assembles the whole request: persona as system instruction, the
+// background history picked by the configured strategy, the reply chain as
+// alternating user/model turns and finally the current message. Everything has
+// to fit into the token budget, the oldest history goes first.
+func Build(ctx context.Context, input Input, current []llm.Part) *llm.Request {
+	config := configuration.Config.Context
+	strategy := config.Strategy
+
+	var chain []database.IndexedMessages
+	if strategy == StrategyThread || strategy == StrategyMixed {
+		chain = input.ReplyChain
+	}
+	if err := database.LoadAttachments(chain); err != nil {
+		log.Errorf("Failed to load attachments of the reply chain: %v", err)
+	}
+
+	var history []database.IndexedMessages
+	if strategy == StrategyChannel || strategy == StrategyMixed {
+		history = append(history, ChannelHistory(input.ChannelID, config.ChannelMessages)...)
+	}
+	if strategy == StrategyAuthor || strategy == StrategyMixed {
+		history = append(history, AuthorHistory(input.UserID, input.GuildID, config.AuthorMessages)...)
+	}
+
+	exclude := map[string]bool{input.MessageID: true}
+	for _, message := range chain {
+		exclude[message.MessageID] = true
+	}
+	history = Timeline(history, exclude)
+	if err := database.LoadAttachments(history); err != nil {
+		log.Errorf("Failed to load attachments of the history: %v", err)
+	}
+
+	for _, message := range history {
+		exclude[message.MessageID] = true
+	}
+	related := RelatedText(ctx, input, exclude)
+
+	recent := append(append([]database.IndexedMessages{}, history...), chain...)
+	attachmentParts := HistoryAttachmentParts(recent, configuration.Config.Attachments.HistoryAttachments)
+
+	//Whatever is left after the prompt, the related messages, the chain, the attachments and the question goes to background history
+	remaining := func() int {
+		budget := config.TokenBudget - llm.EstimateTokens(PROMPT) - llm.EstimateTokens(related) - llm.EstimateParts(attachmentParts) - llm.EstimateParts(current)
+		for _, message := range chain {
+			budget -= llm.EstimateTokens(FormatMessage(message))
+		}
+		return budget
+	}
+	//Once there is no history left to drop, the history attachments go, then the related messages
+	shrink := func() bool {
+		switch {
+		case len(attachmentParts) > 0:
+			log.Debugf("Dropped %d history attachment parts to fit the token budget", len(attachmentParts))
+			attachmentParts = nil
+		case related != "":
+			log.Debug("Dropped related messages to fit the token budget")
+			related = ""
+		default:
+			return false
+		}
+		return true
+	}
+
+	budget := remaining()
+	for budget < 0 && shrink() {
+		budget = remaining()
+	}
+	if budget < 0 {
+		log.Warnf("Request is %d tokens over the budget without any history", -budget)
+	}
+
+	fitted := FitBudget(history, budget)
+	request := assemble(input, related, fitted, attachmentParts, chain, current)
+	if !config.CountTokens {
+		return request
+	}
+
+	//The estimate can be off, the provider knows better. Overshoots are taken
+	//from the history budget with some margin until the request fits.
+	for attempt := 0; attempt < 5; attempt++ {
+		counted := llm.CountTokens(ctx, llm.Default, request)
+		over := counted - config.TokenBudget
+		if over <= 0 {
+			log.Debugf("Request takes %d of %d tokens", counted, config.TokenBudget)
+			break
+		}
+
+		if len(fitted) > 0 {
+			log.Debugf("Request is %d tokens over the budget, trimming history", over)
+			budget -= over + over/10
+			fitted = FitBudget(history, budget)
+		} else if !shrink() {
+			log.Warnf("Request is %d tokens over the budget with nothing left to drop", over)
+			break
+		}
+		request = assemble(input, related, fitted, attachmentParts, chain, current)
+	}
+
+	return request
+}
+
+// assemble puts the pieces of a request together in order
+func assemble(input Input, related string, history []database.IndexedMessages, attachmentParts []llm.Part, chain []database.IndexedMessages, current []llm.Part) *llm.Request {
+	var turns []llm.Message
+	if related != "" {
+		turns = append(turns, llm.UserMessage(llm.TextPart(related)))
+	}
+	if len(history) > 0 {
+		var text strings.Builder
+		for _, message := range history {
+			text.WriteString(FormatMessage(message) + "\n")
+		}
+		turns = append(turns, llm.UserMessage(llm.TextPart(text.String())))
+	}
+
+	if len(attachmentParts) > 0 {
+		turns = append(turns, llm.UserMessage(attachmentParts...))
+	}
+
+	turns = append(turns, Turns(chain, input.BotID)...)
+	turns = append(turns, llm.UserMessage(current...))
+
+	return &llm.Request{
+		System:   PROMPT,
+		Messages: MergeTurns(turns),
+	}
+}
+
+// RelatedText looks up past messages and facts similar to the question and
+// renders them as described in the base prompt, empty if there is nothing
+func RelatedText(ctx context.Context, input Input, exclude map[string]bool) string {
+	related, err := embeddings.Find(ctx, embeddings.Query{
+		GuildID:   input.GuildID,
+		ChannelID: input.ChannelID,
+		Text:      input.Question,
+		Exclude:   exclude,
+		Visible:   input.Visible,
+	})
+	if err != nil {
+		log.Errorf("Failed to find related messages: %v", err)
+		return ""
+	}
+	if err := database.LoadAttachments(related.Messages); err != nil {
+		log.Errorf("Failed to load attachments of related messages: %v", err)
+	}
+
+	var text strings.Builder
+	if len(related.Messages) > 0 {
+		text.WriteString("Похожие сообщения из прошлого:\n")
+		for _, message := range related.Messages {
+			text.WriteString(FormatMessage(message) + "\n")
+		}
+	}
+	if len(related.Facts) > 0 {
+		text.WriteString("Факты, которые могут пригодиться:\n")
+		for _, fact := range related.Facts {
+			text.WriteString(fmt.Sprintf("<@%d>: %s\n", fact.UserID, fact.Fact))
+		}
+	}
+
+	return text.String()
+}
+
+// ChannelHistory returns the last limit messages of a channel
+func ChannelHistory(channelID string, limit int) []database.IndexedMessages {
+	var messages []database.IndexedMessages
+	if limit <= 0 || channelID == "" {
+		return messages
+	}
+
+	if err := database.Pool.Order("created_at DESC").Where("channel_id = ?", channelID).Limit(limit).Find(&messages).Error; err != nil {
+		log.Errorf("Failed to retrieve messages of channel %s from database: %v", channelID, err)
+		return nil
+	}
+
+	return messages
+}
+
+// AuthorHistory returns the last limit messages a user wrote in a guild
+func AuthorHistory(userID string, guildID string, limit int) []database.IndexedMessages {
+	var messages []database.IndexedMessages
+	if limit <= 0 {
+		return messages
+	}
+
+	if err := database.Pool.Order("created_at DESC").Where("author_id = ? AND guild_id = ?", userID, guildID).Limit(limit).Find(&messages).Error; err != nil {
+		log.Errorf("Failed to retrieve messages of %s from database: %v", userID, err)
+		return nil
+	}
+
+	for _, message := range messages {
+		if message.Content == "" {
+			log.Warnf("Message with ID %s in channel %s has empty content", message.MessageID, message.ChannelName)
+		}
+	}
+
+	return messages
+}
+
+// Timeline drops duplicates and excluded messages and sorts the rest oldest first
+func Timeline(messages []database.IndexedMessages, exclude map[string]bool) []database.IndexedMessages {
+	seen := make(map[string]bool, len(messages))
+	timeline := make([]database.IndexedMessages, 0, len(messages))
+
+	for _, message := range messages {
+		if seen[message.MessageID] || exclude[message.MessageID] {
+			continue
+		}
+		seen[message.MessageID] = true
+		timeline = append(timeline, message)
+	}
+
+	sort.SliceStable(timeline, func(i, j int) bool {
+		return timeline[i].CreatedAt < timeline[j].CreatedAt
+	})
+
+	return timeline
+}
+
+// FitBudget keeps the newest messages of a timeline that fit into budget tokens
+func FitBudget(timeline []database.IndexedMessages, budget int) []database.IndexedMessages {
+	start := len(timeline)
+	for start > 0 {
+		cost := llm.EstimateTokens(FormatMessage(timeline[start-1]))
+		if cost > budget {
+			break
+		}
+		budget -= cost
+		start--
+	}
+
+	if start > 0 {
+		log.Debugf("Dropped %d oldest messages to fit the token budget", start)
+	}
+
+	return timeline[start:]
+}
+
+// FitFacts keeps the most recently learned facts that fit into budget tokens,
+// newest first. A budget of zero or less keeps everything.
+func FitFacts(facts []database.UserFact, budget int) []database.UserFact {
+	sorted := append([]database.UserFact{}, facts...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].UpdatedAt != sorted[j].UpdatedAt {
+			return sorted[i].UpdatedAt > sorted[j].UpdatedAt
+		}
+		return sorted[i].Id > sorted[j].Id
+	})
+	if budget <= 0 {
+		return sorted
+	}
+
+	end := 0
+	for end < len(sorted) {
+		cost := llm.EstimateTokens(sorted[end].Fact)
+		if cost > budget {
+			break
+		}
+		budget -= cost
+		end++
+	}
+
+	if end < len(sorted) {
+		log.Debugf("Dropped %d oldest facts of user %d to fit the token budget", len(sorted)-end, sorted[0].UserID)
+	}
+
+	return sorted[:end]
+}
+
+// FormatMessage renders a message in the format described in the base prompt,
+// attachments are described after the content
+func FormatMessage(message database.IndexedMessages) string {
+	text := message.GuildID + "/" + message.GuildName + "/" + message.ChannelID + "/" + message.ChannelName + "/" + message.Username + "/" + message.AuthorID + "/" + message.MessageID + ": " + message.Content
+
+	for _, attachment := range message.Attachments {
+		text += " " + attachments.Describe(attachment)
+		if attachment.Content != "" {
+			text += "\n" + attachment.Content
+		}
+	}
+
+	return text
+}
+
+// HistoryAttachmentParts loads up to limit of the newest cached files small enough
+// to be sent inline, so the model can look at files sent earlier again
+func HistoryAttachmentParts(messages []database.IndexedMessages, limit int) []llm.Part {
+	var parts []llm.Part
+
+	for i := len(messages) - 1; i >= 0 && len(parts) < limit*2; i-- {
+		for _, attachment := range messages[i].Attachments {
+			if attachment.LocalPath == "" || len(parts) >= limit*2 {
+				continue
+			}
+			if mode, err := attachments.Check(llm.Default, attachment.ContentType, attachment.Size); err != nil || mode != attachments.ModeInline {
+				continue
+			}
+
+			data, err := attachments.Load(attachment)
+			if err != nil {
+				log.Errorf("Failed to load cached attachment %s: %v", attachment.Filename, err)
+				continue
+			}
+
+			parts = append(parts,
+				llm.TextPart(fmt.Sprintf("Attachment %s from message %s:", attachment.AttachmentID, attachment.MessageID)),
+				llm.Part{MimeType: attachments.MediaType(attachment.ContentType), Data: data},
+			)
+		}
+	}
+
+	return parts
+}
+
+// ReplyChain follows ReferenceMessageID starting at messageID and returns the
+// chain oldest first. Messages that aren't indexed are loaded with fetch, if given.
+func ReplyChain(messageID string, depth int, fetch Fetcher) []database.IndexedMessages {
+	var chain []database.IndexedMessages
+	seen := map[string]bool{}
+
+	for messageID != "" && len(chain) < depth && !seen[messageID] {
+		seen[messageID] = true
+
+		var message database.IndexedMessages
+		if err := database.Pool.Where("message_id = ?", messageID).Limit(1).Find(&message).Error; err != nil {
+			log.Errorf("Failed to retrieve message %s from database: %v", messageID, err)
+			break
+		}
+		if message.ID == 0 && fetch != nil {
+			fetched, err := fetch(messageID)
+			if err != nil {
+				log.Errorf("Failed to fetch message %s: %v", messageID, err)
+				break
+			}
+			message = *fetched
+		}
+		if message.MessageID == "" {
+			log.Debugf("Message %s is not indexed, reply chain stops here", messageID)
+			break
+		}
+
+		chain = append(chain, message)
+		messageID = message.ReferenceMessageID
+	}
+
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+
+	return chain
+}
+
+// Turns converts indexed messages to conversation turns: messages written by
+// the bot become model turns, everything else user turns
+func Turns(messages []database.IndexedMessages, botID string) []llm.Message {
+	turns := make([]llm.Message, 0, len(messages))
+
+	for _, message := range messages {
+		if message.AuthorID == botID {
+			turns = append(turns, llm.Message{Role: llm.RoleModel, Parts: []llm.Part{llm.TextPart(message.Content)}})
+			continue
+		}
+		turns = append(turns, llm.UserMessage(llm.TextPart(FormatMessage(message))))
+	}
+
+	return turns
+}
+
+// MergeTurns joins consecutive turns of the same role, models expect them to alternate
+func MergeTurns(turns []llm.Message) []llm.Message {
+	merged := make([]llm.Message, 0, len(turns))
+
+	for _, turn := range turns {
+		if len(turn.Parts) == 0 {
+			continue
+		}
+		if len(merged) > 0 && merged[len(merged)-1].Role == turn.Role {
+			merged[len(merged)-1].Parts = append(merged[len(merged)-1].Parts, turn.Parts...)
+			continue
+		}
+		merged = append(merged, turn)
+	}
+
+	return merged
+}
@@ -0,0 +1,43 @@
+package conversation
+
+import (
+	_ "embed"
+
+	"github.com/DHCPCD9/go-swaga-bot/llm"
+)
+
+//go:embed base-prompt.txt
+var PROMPT string
+
+type PromptJson struct {
+	UserID     string   `json:"user_id"`
+	Username   string   `json:"username"`
+	KnownNames []string `json:"known_names"`
+	Activities []struct {
+		Activity string `json:"activity"`
+		State    string `json:"state"`
+		Substate string `json:"substate"`
+	} `json:"activities"`
+	Facts      []string `json:"facts"`
+	Text       string   `json:"text"`
+	Reference  string   `json:"reference"`
+	References []struct {
+		ID   string `json:"id"`
+		Text string `json:"text"`
+		User string `json:"user"`
+	} `json:"references"`
+	ReferenceUsers []struct {
+		ID         string   `json:"id"`
+		Username   string   `json:"username"`
+		KnownNames []string `json:"known_names"`
+		Facts      []string `json:"facts"`
+	} `json:"reference_users"`
+}
+
+// ResponseJson is the final answer, facts and nicknames are changed with tools
+type ResponseJson struct {
+	Response string `json:"response" description:"Ответ пользователю"`
+}
+
+// ResponseSchema is sent to the model as structured output description
+var ResponseSchema = llm.SchemaFor(ResponseJson{})
@@ -0,0 +1,68 @@
+package conversation
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ParseResponse decodes a model answer into ResponseJson. Structured output
+// should give us clean JSON, but models still sometimes wrap it in a markdown
+// fence, add some chatter around it or get a single field wrong, so it falls
+// back step by step, and as the last resort treats the whole answer as text.
+func ParseResponse(answer string) (*ResponseJson, error) {
+	answer = stripCodeFence(strings.TrimSpace(answer))
+	if answer == "" {
+		return nil, fmt.Errorf("empty answer")
+	}
+
+	var parsed ResponseJson
+	if err := json.Unmarshal([]byte(answer), &parsed); err == nil {
+		//Valid JSON without a response has nothing to show, not even as text
+		if parsed.Response == "" {
+			return nil, fmt.Errorf("answer has no response")
+		}
+		return &parsed, nil
+	}
+
+	start := strings.Index(answer, "{")
+	end := strings.LastIndex(answer, "}")
+	if start != -1 && end > start {
+		if lenient, ok := parseLenient(answer[start : end+1]); ok {
+			return lenient, nil
+		}
+	}
+
+	return &ResponseJson{Response: answer}, nil
+}
+
+func stripCodeFence(answer string) string {
+	if !strings.HasPrefix(answer, "```") {
+		return answer
+	}
+
+	answer = strings.TrimPrefix(answer, "```")
+	//Language tag like json goes until the end of the first line
+	if newline := strings.Index(answer, "\n"); newline != -1 {
+		answer = answer[newline+1:]
+	}
+	answer = strings.TrimSuffix(strings.TrimSpace(answer), "```")
+
+	return strings.TrimSpace(answer)
+}
+
+// parseLenient decodes only the known fields, so extra or broken ones the
+// model made up don't cost us the actual response
+func parseLenient(answer string) (*ResponseJson, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(answer), &fields); err != nil {
+		return nil, false
+	}
+
+	var parsed ResponseJson
+	if err := json.Unmarshal(fields["response"], &parsed.Response); err != nil || parsed.Response == "" {
+		return nil, false
+	}
+
+	return &parsed, true
+}
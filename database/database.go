@@ -1,11 +1,18 @@
 package database
 
 import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/DHCPCD9/go-swaga-bot/configuration"
 	"github.com/glebarez/sqlite"
 	log "github.com/sirupsen/logrus"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 )
 
@@ -16,6 +23,10 @@ type KnownUsers struct {
 	Username   string     `gorm:"unique"`
 	KnownNames []UserName `gorm:"foreignKey:UserID"`
 	Facts      []UserFact `gorm:"foreignKey:UserID"`
+	// OptedOut users are neither indexed nor remembered
+	OptedOut bool `gorm:"default:false"`
+	// DMDisabled users are ignored in direct messages
+	DMDisabled bool `gorm:"default:false"`
 	CreatedAt  int64
 }
 
@@ -23,6 +34,14 @@ type UserFact struct {
 	Id     uint64 `gorm:"primaryKey;autoIncrement"`
 	Fact   string
 	UserID uint64
+	// Where the fact was learned, empty for facts from before this was kept
+	MessageID  string
+	GuildID    string
+	Model      string  // that proposed the fact
+	Confidence float64 // 0..1 as rated by the model, 0 when unknown
+	CreatedAt  int64
+	UpdatedAt  int64 `gorm:"index"` // learned again later
+	ExpiresAt  int64 `gorm:"index"` // 0 never expires
 }
 
 type UserName struct {
@@ -35,14 +54,91 @@ type IndexedMessages struct {
 	ID                 uint   `gorm:"primaryKey"`
 	MessageID          string `gorm:"unique"`
 	Content            string `gorm:"type:text"`
-	ChannelID          string `gorm:"index"`
+	ChannelID          string `gorm:"index;index:idx_channel_created,priority:1"`
 	ChannelName        string `gorm:"index"`
-	GuildID            string `gorm:"index"`
+	GuildID            string `gorm:"index;index:idx_author_created,priority:2"`
 	GuildName          string `gorm:"index"`
-	AuthorID           string `gorm:"index"`
+	AuthorID           string `gorm:"index;index:idx_author_created,priority:1"`
 	Username           string `gorm:"index"`
 	ReferenceMessageID string `gorm:"index"`
-	CreatedAt          int64
+	CreatedAt          int64  `gorm:"index;index:idx_channel_created,priority:2;index:idx_author_created,priority:3"`
+	EditedAt           int64
+	// Deleted messages are soft deleted, so gorm keeps them out of every query
+	DeletedAt   gorm.DeletedAt       `gorm:"index"`
+	Attachments []IndexedAttachments `gorm:"foreignKey:MessageID;references:MessageID"`
+}
+
+// IndexedAttachments describe files sent with indexed messages. Hash, LocalPath
+// and Content are filled once the file is downloaded, if caching is enabled.
+type IndexedAttachments struct {
+	ID           uint   `gorm:"primaryKey"`
+	AttachmentID string `gorm:"unique"`
+	MessageID    string `gorm:"index"`
+	AuthorID     string `gorm:"index"`
+	Filename     string
+	ContentType  string
+	Size         int64
+	URL          string
+	Hash         string `gorm:"index"`
+	LocalPath    string
+	// Content is the extracted text of text-like files
+	Content   string `gorm:"type:text"`
+	CreatedAt int64
+}
+
+// IndexedMessageEdits keeps the previous content of edited messages
+type IndexedMessageEdits struct {
+	ID        uint   `gorm:"primaryKey"`
+	MessageID string `gorm:"index"`
+	AuthorID  string `gorm:"index"`
+	Content   string `gorm:"type:text"`
+	EditedAt  int64
+}
+
+// ChannelRule is a runtime override of the channel rules from config.yml
+type ChannelRule struct {
+	ID       uint   `gorm:"primaryKey"`
+	GuildID  string `gorm:"uniqueIndex:idx_channel_rule"`
+	TargetID string `gorm:"uniqueIndex:idx_channel_rule"`
+	Scope    string `gorm:"uniqueIndex:idx_channel_rule"` // index | reply
+	Action   string // allow | deny
+}
+
+// BackfillCheckpoints remember how far back a channel history was imported
+type BackfillCheckpoints struct {
+	ChannelID string `gorm:"primaryKey"`
+	GuildID   string `gorm:"index"`
+	// BeforeID is the oldest message imported so far, the next page starts before it
+	BeforeID  string
+	Indexed   int64
+	Done      bool
+	UpdatedAt int64
+}
+
+// LLMUsage is one answered model request
+type LLMUsage struct {
+	ID               uint   `gorm:"primaryKey"`
+	CreatedAt        int64  `gorm:"index"`
+	GuildID          string `gorm:"index"`
+	ChannelID        string
+	UserID           string `gorm:"index"`
+	Provider         string
+	Model            string
+	PromptTokens     int
+	CandidatesTokens int
+	TotalTokens      int
+	LatencyMs        int64
+	FinishReason     string
+	// Cost in USD at the prices configured when the request was made
+	Cost float64
+}
+
+// UsageTotal sums up usage grouped by Key: a day, a guild or a user
+type UsageTotal struct {
+	Key         string `gorm:"column:usage_key"`
+	Requests    int64
+	TotalTokens int64
+	Cost        float64
 }
 
 func InitDatabase() error {
@@ -66,49 +162,92 @@ func InitDatabase() error {
 	}
 
 	log.Info("Database opened successfully")
-	db.AutoMigrate(&KnownUsers{}, &IndexedMessages{}, &UserName{}, &UserFact{})
+	db.AutoMigrate(&KnownUsers{}, &IndexedMessages{}, &IndexedMessageEdits{}, &IndexedAttachments{}, &UserName{}, &UserFact{}, &ChannelRule{}, &BackfillCheckpoints{}, &LLMUsage{}, &Embedding{})
 	log.Info("Database migrated successfully")
+	initSearch(db)
+	initVectors(db)
+
+	//Facts from before timestamps were kept start aging now
+	now := time.Now().Unix()
+	db.Model(&UserFact{}).Where("updated_at = 0 OR updated_at IS NULL").UpdateColumns(map[string]any{"created_at": now, "updated_at": now})
 
 	Pool = db
 	log.Info("Database initialized successfully")
 	return nil
 }
 
-func AddUsername(user uint64, username string) {
-	var knownUser KnownUsers
-	Pool.Where(&KnownUsers{ID: user}).Attrs(&KnownUsers{ID: user}).FirstOrCreate(&knownUser)
-	Pool.Save(&knownUser)
+// ErrOptedOut is returned when storing something about a user who opted out
+var ErrOptedOut = errors.New("user opted out")
 
-	// knownUser.KnownNames = append(knownUser.KnownNames, UserName{Name: username})
-	Pool.Create(&UserName{Name: username, UserID: user})
+// AddUsername remembers a nickname, known ones are not added twice
+func AddUsername(user uint64, username string) error {
+	if IsOptedOut(user) {
+		return ErrOptedOut
+	}
+
+	var knownUser KnownUsers
+	if err := Pool.Where(&KnownUsers{ID: user}).Attrs(&KnownUsers{ID: user}).FirstOrCreate(&knownUser).Error; err != nil {
+		return err
+	}
 
+	var count int64
+	Pool.Model(&UserName{}).Where("name = ? AND user_id = ?", username, user).Count(&count)
+	if count > 0 {
+		return nil
+	}
+	return Pool.Create(&UserName{Name: username, UserID: user}).Error
 }
 
-func AddFact(user uint64, fact string) {
+// AddFact remembers fact.Fact about fact.UserID. A known fact isn't added twice,
+// learning it again refreshes its provenance and expiry instead.
+func AddFact(fact UserFact) error {
+	if IsOptedOut(fact.UserID) {
+		return ErrOptedOut
+	}
+
 	var knownUser KnownUsers
-	Pool.Where(&KnownUsers{ID: user}).Attrs(&KnownUsers{ID: user}).FirstOrCreate(&knownUser)
-	Pool.Save(&knownUser)
+	if err := Pool.Where(&KnownUsers{ID: fact.UserID}).Attrs(&KnownUsers{ID: fact.UserID}).FirstOrCreate(&knownUser).Error; err != nil {
+		return err
+	}
 
-	k := Pool.Create(&UserFact{Fact: fact, UserID: user})
-	log.Println(k.Statement.Error)
+	var known UserFact
+	result := Pool.Where("fact = ? AND user_id = ?", fact.Fact, fact.UserID).Limit(1).Find(&known)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return Pool.Create(&fact).Error
+	}
+
+	return Pool.Model(&known).Updates(map[string]any{
+		"message_id": fact.MessageID,
+		"guild_id":   fact.GuildID,
+		"model":      fact.Model,
+		"confidence": fact.Confidence,
+		"expires_at": fact.ExpiresAt,
+	}).Error
 }
 
-func RemoveFact(user uint64, fact string) {
-	var knownUser KnownUsers
-	Pool.Where(&KnownUsers{ID: user}).Attrs(&KnownUsers{ID: user}).FirstOrCreate(&knownUser)
+// PruneFacts deletes expired facts and, when maxAge is set, facts not learned again for that long
+func PruneFacts(maxAge time.Duration) (int64, error) {
+	now := time.Now()
+	query := Pool.Where("expires_at > 0 AND expires_at <= ?", now.Unix())
+	if maxAge > 0 {
+		query = query.Or("updated_at < ?", now.Add(-maxAge).Unix())
+	}
 
-	// index := slices.Index(knownUser.Facts, fact)
-	// if index != -1 {
-	// 	knownUser.Facts = append(knownUser.Facts[:index], knownUser.Facts[index+1:]...)
-	// }
-	var factFact UserFact
+	result := query.Delete(&UserFact{})
+	return result.RowsAffected, result.Error
+}
 
-	Pool.First(&factFact, "fact = ? AND user_id = ?", fact, user)
-	Pool.Delete(&factFact)
+// RemoveFact deletes a fact by its text, reports whether it was known
+func RemoveFact(user uint64, fact string) (bool, error) {
+	result := Pool.Where("fact = ? AND user_id = ?", fact, user).Delete(&UserFact{})
+	return result.RowsAffected > 0, result.Error
 }
 
 func NamestToStrings(names []UserName) []string {
-	res := make([]string, len(names))
+	res := make([]string, 0, len(names))
 
 	for _, name := range names {
 		res = append(res, name.Name)
@@ -118,7 +257,7 @@ func NamestToStrings(names []UserName) []string {
 }
 
 func FactsToStrings(names []UserFact) []string {
-	res := make([]string, len(names))
+	res := make([]string, 0, len(names))
 
 	for _, name := range names {
 		res = append(res, name.Fact)
@@ -126,3 +265,465 @@ func FactsToStrings(names []UserFact) []string {
 
 	return res
 }
+
+// RemoveUsername deletes a nickname, reports whether it was known
+func RemoveUsername(user uint64, username string) (bool, error) {
+	result := Pool.Where("name = ? AND user_id = ?", username, user).Delete(&UserName{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// FindUsers returns IDs of users seen in a guild whose nickname or indexed username contains name
+func FindUsers(guildID string, name string, limit int) ([]uint64, error) {
+	pattern := "%" + strings.ToLower(name) + "%"
+
+	var ids []uint64
+	err := Pool.Model(&UserName{}).Distinct("user_id").Where("LOWER(name) LIKE ?", pattern).
+		Where("CAST(user_id AS TEXT) IN (SELECT author_id FROM indexed_messages WHERE guild_id = ? AND deleted_at IS NULL)", guildID).
+		Limit(limit).Pluck("user_id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var authors []string
+	if err := Pool.Model(&IndexedMessages{}).Distinct("author_id").Where("guild_id = ? AND LOWER(username) LIKE ?", guildID, pattern).Limit(limit).Pluck("author_id", &authors).Error; err != nil {
+		return nil, err
+	}
+	for _, author := range authors {
+		id, err := strconv.ParseUint(author, 10, 64)
+		if err == nil && !slices.Contains(ids, id) && len(ids) < limit {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids, nil
+}
+
+// MessagesByID loads messages in no particular order, deleted ones are left out
+func MessagesByID(messageIDs []string) ([]IndexedMessages, error) {
+	var messages []IndexedMessages
+	if len(messageIDs) == 0 {
+		return messages, nil
+	}
+	err := Pool.Where("message_id IN ?", messageIDs).Find(&messages).Error
+	return messages, err
+}
+
+// FactsByID loads facts that haven't expired
+func FactsByID(ids []uint64) ([]UserFact, error) {
+	var facts []UserFact
+	if len(ids) == 0 {
+		return facts, nil
+	}
+	err := Pool.Scopes(unexpired).Where("id IN ?", ids).Find(&facts).Error
+	return facts, err
+}
+
+// GuildAuthors returns which of userIDs wrote anything in the guild
+func GuildAuthors(guildID string, userIDs []string) (map[string]bool, error) {
+	authors := map[string]bool{}
+	if len(userIDs) == 0 {
+		return authors, nil
+	}
+
+	var found []string
+	err := Pool.Model(&IndexedMessages{}).Distinct("author_id").
+		Where("guild_id = ? AND author_id IN ?", guildID, userIDs).Pluck("author_id", &found).Error
+	for _, id := range found {
+		authors[id] = true
+	}
+	return authors, err
+}
+
+// unexpired keeps facts that haven't expired yet, pruning may not have run since
+func unexpired(db *gorm.DB) *gorm.DB {
+	return db.Where("expires_at IS NULL OR expires_at = 0 OR expires_at > ?", time.Now().Unix())
+}
+
+// ListFacts returns facts of a user that haven't expired, newest first
+func ListFacts(user uint64) ([]UserFact, error) {
+	var facts []UserFact
+	err := Pool.Scopes(unexpired).Where("user_id = ?", user).Order("updated_at DESC, id DESC").Find(&facts).Error
+	return facts, err
+}
+
+// RemoveFactByID deletes a fact only if it belongs to user, reports whether anything was deleted
+func RemoveFactByID(user uint64, id uint64) (bool, error) {
+	result := Pool.Where("id = ? AND user_id = ?", id, user).Delete(&UserFact{})
+	return result.RowsAffected > 0, result.Error
+}
+
+func ListUsernames(user uint64) ([]UserName, error) {
+	var names []UserName
+	err := Pool.Order("id").Find(&names, "user_id = ?", user).Error
+	return names, err
+}
+
+// RemoveUsernameByID deletes a nickname only if it belongs to user, reports whether anything was deleted
+func RemoveUsernameByID(user uint64, id uint64) (bool, error) {
+	result := Pool.Where("id = ? AND user_id = ?", id, user).Delete(&UserName{})
+	return result.RowsAffected > 0, result.Error
+}
+
+func IsOptedOut(user uint64) bool {
+	var count int64
+	Pool.Model(&KnownUsers{}).Where("id = ? AND opted_out = ?", user, true).Count(&count)
+	return count > 0
+}
+
+func SetOptedOut(user uint64, optedOut bool) error {
+	var knownUser KnownUsers
+	if err := Pool.Where(&KnownUsers{ID: user}).Attrs(&KnownUsers{ID: user}).FirstOrCreate(&knownUser).Error; err != nil {
+		return err
+	}
+
+	return Pool.Model(&knownUser).Update("opted_out", optedOut).Error
+}
+
+func IsDMDisabled(user uint64) bool {
+	var count int64
+	Pool.Model(&KnownUsers{}).Where("id = ? AND dm_disabled = ?", user, true).Count(&count)
+	return count > 0
+}
+
+func SetDMDisabled(user uint64, disabled bool) error {
+	var knownUser KnownUsers
+	if err := Pool.Where(&KnownUsers{ID: user}).Attrs(&KnownUsers{ID: user}).FirstOrCreate(&knownUser).Error; err != nil {
+		return err
+	}
+
+	return Pool.Model(&knownUser).Update("dm_disabled", disabled).Error
+}
+
+type ForgetResult struct {
+	Messages  int64
+	Facts     int64
+	Usernames int64
+	// Files are cached attachment blobs nobody else references anymore
+	Files []string
+}
+
+// ForgetUser deletes everything stored about a user in one transaction. The
+// user row itself survives only to keep the opt-out and DM flags.
+func ForgetUser(user uint64) (*ForgetResult, error) {
+	var result ForgetResult
+
+	err := Pool.Transaction(func(tx *gorm.DB) error {
+		deleted := tx.Unscoped().Where("author_id = ?", strconv.FormatUint(user, 10)).Delete(&IndexedMessages{})
+		if deleted.Error != nil {
+			return deleted.Error
+		}
+		result.Messages = deleted.RowsAffected
+
+		if err := tx.Where("author_id = ?", strconv.FormatUint(user, 10)).Delete(&IndexedMessageEdits{}).Error; err != nil {
+			return err
+		}
+
+		var attachments []IndexedAttachments
+		if err := tx.Where("author_id = ?", strconv.FormatUint(user, 10)).Find(&attachments).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("author_id = ?", strconv.FormatUint(user, 10)).Delete(&IndexedAttachments{}).Error; err != nil {
+			return err
+		}
+		for _, attachment := range attachments {
+			if attachment.LocalPath == "" {
+				continue
+			}
+			var references int64
+			if err := tx.Model(&IndexedAttachments{}).Where("local_path = ?", attachment.LocalPath).Count(&references).Error; err != nil {
+				return err
+			}
+			if references == 0 {
+				result.Files = append(result.Files, attachment.LocalPath)
+			}
+		}
+
+		if err := tx.Where("author_id = ?", strconv.FormatUint(user, 10)).Delete(&Embedding{}).Error; err != nil {
+			return err
+		}
+
+		deleted = tx.Where("user_id = ?", user).Delete(&UserFact{})
+		if deleted.Error != nil {
+			return deleted.Error
+		}
+		result.Facts = deleted.RowsAffected
+
+		deleted = tx.Where("user_id = ?", user).Delete(&UserName{})
+		if deleted.Error != nil {
+			return deleted.Error
+		}
+		result.Usernames = deleted.RowsAffected
+
+		return tx.Where("id = ? AND opted_out = ? AND dm_disabled = ?", user, false, false).Delete(&KnownUsers{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Forgot user %d: %d messages, %d facts, %d usernames deleted", user, result.Messages, result.Facts, result.Usernames)
+	return &result, nil
+}
+
+func ListChannelRules(guildID string) ([]ChannelRule, error) {
+	var rules []ChannelRule
+	err := Pool.Order("scope, target_id").Find(&rules, "guild_id = ?", guildID).Error
+	return rules, err
+}
+
+func SetChannelRule(guildID string, targetID string, scope string, action string) error {
+	return Pool.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "guild_id"}, {Name: "target_id"}, {Name: "scope"}},
+		DoUpdates: clause.AssignmentColumns([]string{"action"}),
+	}).Create(&ChannelRule{GuildID: guildID, TargetID: targetID, Scope: scope, Action: action}).Error
+}
+
+func DeleteChannelRule(guildID string, targetID string, scope string) (bool, error) {
+	result := Pool.Where("guild_id = ? AND target_id = ? AND scope = ?", guildID, targetID, scope).Delete(&ChannelRule{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// EditMessage replaces the content of an indexed message, keeping the old one
+// in IndexedMessageEdits. Reports false if the message isn't indexed or didn't change.
+func EditMessage(messageID string, content string, editedAt int64) (bool, error) {
+	edited := false
+
+	err := Pool.Transaction(func(tx *gorm.DB) error {
+		var message IndexedMessages
+		if err := tx.Where("message_id = ?", messageID).Limit(1).Find(&message).Error; err != nil {
+			return err
+		}
+		if message.ID == 0 || message.Content == content {
+			return nil
+		}
+
+		if err := tx.Create(&IndexedMessageEdits{
+			MessageID: messageID,
+			AuthorID:  message.AuthorID,
+			Content:   message.Content,
+			EditedAt:  editedAt,
+		}).Error; err != nil {
+			return err
+		}
+
+		//The old vector describes the old text, the embeddings worker makes a new one
+		if err := tx.Where("kind = ? AND source_id = ?", EmbeddingMessage, messageID).Delete(&Embedding{}).Error; err != nil {
+			return err
+		}
+
+		edited = true
+		return tx.Model(&message).Updates(map[string]any{"content": content, "edited_at": editedAt}).Error
+	})
+
+	return edited, err
+}
+
+// DeleteMessages soft deletes indexed messages, returns how many were deleted
+func DeleteMessages(messageIDs ...string) (int64, error) {
+	if len(messageIDs) == 0 {
+		return 0, nil
+	}
+
+	result := Pool.Where("message_id IN ?", messageIDs).Delete(&IndexedMessages{})
+	return result.RowsAffected, result.Error
+}
+
+// GetBackfillCheckpoint returns the checkpoint of a channel, a fresh one if there is none
+func GetBackfillCheckpoint(channelID string) (*BackfillCheckpoints, error) {
+	checkpoint := BackfillCheckpoints{ChannelID: channelID}
+	err := Pool.Where("channel_id = ?", channelID).Limit(1).Find(&checkpoint).Error
+	return &checkpoint, err
+}
+
+func SaveBackfillCheckpoint(checkpoint *BackfillCheckpoints) error {
+	return Pool.Save(checkpoint).Error
+}
+
+// IndexMessages inserts messages skipping the ones already indexed, returns how many were new.
+// Afterwards only attachments inserted now have an ID, the others are left at zero.
+func IndexMessages(messages []*IndexedMessages) (int64, error) {
+	if len(messages) == 0 {
+		return 0, nil
+	}
+
+	var indexed int64
+	err := Pool.Transaction(func(tx *gorm.DB) error {
+		messageIDs := make([]string, 0, len(messages))
+		for _, message := range messages {
+			messageIDs = append(messageIDs, message.MessageID)
+		}
+		//Deleted messages keep their row, so they count as indexed too
+		var existing []string
+		if err := tx.Unscoped().Model(&IndexedMessages{}).Where("message_id IN ?", messageIDs).Pluck("message_id", &existing).Error; err != nil {
+			return err
+		}
+
+		//Skipped rows would get IDs meant for others, so only new ones are inserted
+		fresh := make([]*IndexedMessages, 0, len(messages))
+		for _, message := range messages {
+			message.ID = 0
+			if !slices.Contains(existing, message.MessageID) {
+				fresh = append(fresh, message)
+			}
+		}
+		if len(fresh) > 0 {
+			result := tx.Omit("Attachments").Clauses(clause.OnConflict{DoNothing: true}).Create(&fresh)
+			if result.Error != nil {
+				return result.Error
+			}
+			indexed = result.RowsAffected
+		}
+
+		var rows []IndexedAttachments
+		var attachmentIDs []string
+		for _, message := range messages {
+			for i := range message.Attachments {
+				message.Attachments[i].ID = 0
+				rows = append(rows, message.Attachments[i])
+				attachmentIDs = append(attachmentIDs, message.Attachments[i].AttachmentID)
+			}
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+
+		var known []string
+		if err := tx.Model(&IndexedAttachments{}).Where("attachment_id IN ?", attachmentIDs).Pluck("attachment_id", &known).Error; err != nil {
+			return err
+		}
+		rows = slices.DeleteFunc(rows, func(row IndexedAttachments) bool {
+			return slices.Contains(known, row.AttachmentID)
+		})
+		if len(rows) == 0 {
+			return nil
+		}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error; err != nil {
+			return err
+		}
+
+		//Reloaded, so the IDs surely belong to the rows
+		inserted := make([]string, 0, len(rows))
+		for _, row := range rows {
+			inserted = append(inserted, row.AttachmentID)
+		}
+		var stored []IndexedAttachments
+		if err := tx.Where("attachment_id IN ?", inserted).Find(&stored).Error; err != nil {
+			return err
+		}
+		for _, message := range messages {
+			for i := range message.Attachments {
+				for _, row := range stored {
+					if row.AttachmentID == message.Attachments[i].AttachmentID {
+						message.Attachments[i] = row
+					}
+				}
+			}
+		}
+		return nil
+	})
+
+	return indexed, err
+}
+
+// UpdateAttachment stores what was learned by downloading an attachment
+func UpdateAttachment(attachment *IndexedAttachments) error {
+	return Pool.Model(attachment).Select("hash", "local_path", "content").Updates(attachment).Error
+}
+
+// LoadAttachments fills Attachments of the given messages
+func LoadAttachments(messages []IndexedMessages) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(messages))
+	for _, message := range messages {
+		ids = append(ids, message.MessageID)
+	}
+
+	var attachments []IndexedAttachments
+	if err := Pool.Where("message_id IN ?", ids).Order("id").Find(&attachments).Error; err != nil {
+		return err
+	}
+
+	byMessage := map[string][]IndexedAttachments{}
+	for _, attachment := range attachments {
+		byMessage[attachment.MessageID] = append(byMessage[attachment.MessageID], attachment)
+	}
+	for i := range messages {
+		messages[i].Attachments = byMessage[messages[i].MessageID]
+	}
+
+	return nil
+}
+
+func RecordUsage(usage *LLMUsage) error {
+	return Pool.Create(usage).Error
+}
+
+// UsageByDay sums usage per UTC day since a unix time, newest first. An empty
+// guildID means every guild.
+func UsageByDay(guildID string, since int64) ([]UsageTotal, error) {
+	var totals []struct {
+		Day         int64
+		Requests    int64
+		TotalTokens int64
+		Cost        float64
+	}
+
+	//Integer division works the same in SQLite and Postgres
+	err := usageSince(guildID, since).
+		Select("created_at / 86400 AS day, COUNT(*) AS requests, SUM(total_tokens) AS total_tokens, SUM(cost) AS cost").
+		Group("day").Order("day DESC").Scan(&totals).Error
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]UsageTotal, 0, len(totals))
+	for _, total := range totals {
+		result = append(result, UsageTotal{
+			Key:         time.Unix(total.Day*86400, 0).UTC().Format("2006-01-02"),
+			Requests:    total.Requests,
+			TotalTokens: total.TotalTokens,
+			Cost:        total.Cost,
+		})
+	}
+	return result, nil
+}
+
+// UsageByGuild sums usage per guild since a unix time, the most expensive first
+func UsageByGuild(since int64) ([]UsageTotal, error) {
+	var totals []UsageTotal
+	err := usageSince("", since).
+		Select("guild_id AS usage_key, COUNT(*) AS requests, SUM(total_tokens) AS total_tokens, SUM(cost) AS cost").
+		Group("guild_id").Order("cost DESC, total_tokens DESC").Scan(&totals).Error
+	return totals, err
+}
+
+// TopUsers returns who used the most tokens since a unix time
+func TopUsers(guildID string, since int64, limit int) ([]UsageTotal, error) {
+	var totals []UsageTotal
+	err := usageSince(guildID, since).
+		Select("user_id AS usage_key, COUNT(*) AS requests, SUM(total_tokens) AS total_tokens, SUM(cost) AS cost").
+		Group("user_id").Order("total_tokens DESC").Limit(limit).Scan(&totals).Error
+	return totals, err
+}
+
+func usageSince(guildID string, since int64) *gorm.DB {
+	query := Pool.Model(&LLMUsage{}).Where("created_at >= ?", since)
+	if guildID != "" {
+		query = query.Where("guild_id = ?", guildID)
+	}
+	return query
+}
+
+// TokensUsed sums the tokens spent in a guild since a unix time, by one user
+// if userID is set
+func TokensUsed(guildID string, userID string, since int64) (int64, error) {
+	var used int64
+	query := Pool.Model(&LLMUsage{}).Where("created_at >= ? AND guild_id = ?", since, guildID)
+	if userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+	err := query.Select("COALESCE(SUM(total_tokens), 0)").Scan(&used).Error
+	return used, err
+}
@@ -0,0 +1,203 @@
+package database
+
+import (
+	"strings"
+
+	"github.com/DHCPCD9/go-swaga-bot/configuration"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	searchFTS5     = "fts5"
+	searchTsvector = "tsvector"
+	searchLike     = "like"
+)
+
+// searchBackend is picked once the database is open, LIKE is the fallback
+// when the full-text index couldn't be created
+var searchBackend = searchLike
+
+// MessageQuery filters SearchMessages, empty fields match everything
+type MessageQuery struct {
+	GuildID   string
+	ChannelID string
+	AuthorID  string
+	// Text has to contain every word, words match by prefix
+	Text   string
+	After  int64 // unix seconds, inclusive
+	Before int64 // unix seconds, exclusive
+	Limit  int
+	Offset int
+	// Visible drops messages of channels the asking user can't see, nil keeps all
+	Visible func(channelID string) bool
+}
+
+// initSearch sets up the full-text index: an FTS5 table kept in sync by
+// triggers on SQLite, a generated tsvector column with a GIN index on Postgres
+func initSearch(db *gorm.DB) {
+	var err error
+	backend := searchFTS5
+	if configuration.Config.Database.Type == "sqlite" {
+		err = initFTS5(db)
+	} else {
+		backend = searchTsvector
+		err = initTsvector(db)
+	}
+
+	if err != nil {
+		log.Errorf("Failed to set up full-text search, falling back to LIKE: %v", err)
+		return
+	}
+
+	searchBackend = backend
+	log.Infof("Full-text search uses %s", backend)
+}
+
+func initFTS5(db *gorm.DB) error {
+	var exists int64
+	if err := db.Raw("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'indexed_messages_fts'").Scan(&exists).Error; err != nil {
+		return err
+	}
+
+	//External content table: the text lives in indexed_messages only, triggers keep the index in step
+	statements := []string{
+		`CREATE VIRTUAL TABLE IF NOT EXISTS indexed_messages_fts USING fts5(content, content='indexed_messages', content_rowid='id', tokenize='unicode61 remove_diacritics 2')`,
+		`CREATE TRIGGER IF NOT EXISTS indexed_messages_fts_insert AFTER INSERT ON indexed_messages BEGIN
+			INSERT INTO indexed_messages_fts(rowid, content) VALUES (new.id, new.content);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS indexed_messages_fts_delete AFTER DELETE ON indexed_messages BEGIN
+			INSERT INTO indexed_messages_fts(indexed_messages_fts, rowid, content) VALUES ('delete', old.id, old.content);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS indexed_messages_fts_update AFTER UPDATE OF content ON indexed_messages BEGIN
+			INSERT INTO indexed_messages_fts(indexed_messages_fts, rowid, content) VALUES ('delete', old.id, old.content);
+			INSERT INTO indexed_messages_fts(rowid, content) VALUES (new.id, new.content);
+		END`,
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+
+	if exists == 0 {
+		log.Info("Building the full-text index of indexed messages")
+		return db.Exec("INSERT INTO indexed_messages_fts(indexed_messages_fts) VALUES ('rebuild')").Error
+	}
+	return nil
+}
+
+func initTsvector(db *gorm.DB) error {
+	//Generated columns are computed by Postgres itself on every insert and update
+	statements := []string{
+		`ALTER TABLE indexed_messages ADD COLUMN IF NOT EXISTS content_tsv tsvector GENERATED ALWAYS AS (to_tsvector('simple', COALESCE(content, ''))) STORED`,
+		`CREATE INDEX IF NOT EXISTS idx_indexed_messages_content_tsv ON indexed_messages USING GIN (content_tsv)`,
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SearchMessages returns indexed messages matching query, newest first.
+// GuildID is always applied, an empty one means direct messages.
+func SearchMessages(query MessageQuery) ([]IndexedMessages, error) {
+	if query.Visible == nil {
+		return searchPage(query)
+	}
+
+	//Hidden channels are filtered after the query, so a few pages may be needed
+	var visible []IndexedMessages
+	readable := map[string]bool{}
+	limit := query.Limit
+
+	for page := 0; page < 5 && len(visible) < limit; page++ {
+		query.Offset = page * limit
+		messages, err := searchPage(query)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, message := range messages {
+			allowed, known := readable[message.ChannelID]
+			if !known {
+				allowed = query.Visible(message.ChannelID)
+				readable[message.ChannelID] = allowed
+			}
+			if allowed && len(visible) < limit {
+				visible = append(visible, message)
+			}
+		}
+
+		if len(messages) < limit {
+			break
+		}
+	}
+
+	return visible, nil
+}
+
+func searchPage(query MessageQuery) ([]IndexedMessages, error) {
+	db := Pool.Where("guild_id = ?", query.GuildID)
+	if query.ChannelID != "" {
+		db = db.Where("channel_id = ?", query.ChannelID)
+	}
+	if query.AuthorID != "" {
+		db = db.Where("author_id = ?", query.AuthorID)
+	}
+	if words := strings.Fields(query.Text); len(words) > 0 {
+		db = matchText(db, words)
+	}
+	if query.After > 0 {
+		db = db.Where("created_at >= ?", query.After)
+	}
+	if query.Before > 0 {
+		db = db.Where("created_at < ?", query.Before)
+	}
+
+	var messages []IndexedMessages
+	err := db.Order("created_at DESC").Limit(query.Limit).Offset(query.Offset).Find(&messages).Error
+	return messages, err
+}
+
+// matchText requires every word, each matching by prefix so different word
+// endings are found too
+func matchText(db *gorm.DB, words []string) *gorm.DB {
+	switch searchBackend {
+	case searchFTS5:
+		terms := make([]string, 0, len(words))
+		for _, word := range words {
+			terms = append(terms, `"`+strings.ReplaceAll(word, `"`, `""`)+`"*`)
+		}
+		return db.Where("id IN (SELECT rowid FROM indexed_messages_fts WHERE indexed_messages_fts MATCH ?)", strings.Join(terms, " "))
+	case searchTsvector:
+		terms := make([]string, 0, len(words))
+		for _, word := range words {
+			//Anything but letters and digits would be tsquery syntax
+			word = strings.Map(func(r rune) rune {
+				if strings.ContainsRune(`&|!():*'\<>`, r) {
+					return -1
+				}
+				return r
+			}, word)
+			if word != "" {
+				terms = append(terms, word+":*")
+			}
+		}
+		if len(terms) == 0 {
+			return db
+		}
+		return db.Where("content_tsv @@ to_tsquery('simple', ?)", strings.Join(terms, " & "))
+	default:
+		for _, word := range words {
+			db = db.Where("LOWER(content) LIKE ? ESCAPE '\\'", "%"+escapeLike(strings.ToLower(word))+"%")
+		}
+		return db
+	}
+}
+
+func escapeLike(text string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(text)
+}
@@ -0,0 +1,181 @@
+package database
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/DHCPCD9/go-swaga-bot/configuration"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	EmbeddingMessage = "message"
+	EmbeddingFact    = "fact"
+)
+
+// Embedding is the vector of a message or a fact. Vectors of different models
+// don't mix, so Model is part of the key and every query filters by it.
+type Embedding struct {
+	ID         uint   `gorm:"primaryKey"`
+	Kind       string `gorm:"uniqueIndex:idx_embedding_source"` // message | fact
+	SourceID   string `gorm:"uniqueIndex:idx_embedding_source"` // message ID or fact ID
+	Model      string `gorm:"uniqueIndex:idx_embedding_source"`
+	GuildID    string `gorm:"index"` // empty for facts, they belong to users
+	ChannelID  string
+	AuthorID   string `gorm:"index"`
+	Dimensions int
+	Vector     []byte // little endian float32s
+	CreatedAt  int64
+}
+
+// ScoredEmbedding is a search hit, Similarity is the cosine similarity
+type ScoredEmbedding struct {
+	Embedding
+	Similarity float64
+}
+
+// VectorIndex is true when Postgres has pgvector, searches then run in the
+// database instead of in memory
+var VectorIndex = false
+
+// initVectors adds a pgvector column next to the portable blob. Without the
+// extension everything still works, only searches happen in memory.
+func initVectors(db *gorm.DB) {
+	if configuration.Config.Database.Type == "sqlite" {
+		return
+	}
+
+	statements := []string{
+		`CREATE EXTENSION IF NOT EXISTS vector`,
+		`ALTER TABLE embeddings ADD COLUMN IF NOT EXISTS vector_pg vector`,
+	}
+	//Indexes need a fixed size, rows of other sizes are left out of it
+	if dimensions := configuration.Config.Embeddings.Dimensions; dimensions > 0 {
+		statements = append(statements, `CREATE INDEX IF NOT EXISTS idx_embeddings_vector_`+strconv.Itoa(dimensions)+
+			` ON embeddings USING hnsw ((vector_pg::vector(`+strconv.Itoa(dimensions)+`)) vector_cosine_ops) WHERE dimensions = `+strconv.Itoa(dimensions))
+	}
+
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			log.Warnf("pgvector is not available, vector search runs in memory: %v", err)
+			return
+		}
+	}
+
+	VectorIndex = true
+	log.Info("Vector search uses pgvector")
+}
+
+func EncodeVector(vector []float32) []byte {
+	data := make([]byte, 4*len(vector))
+	for i, value := range vector {
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(value))
+	}
+	return data
+}
+
+func DecodeVector(data []byte) []float32 {
+	vector := make([]float32, len(data)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+	}
+	return vector
+}
+
+// vectorLiteral renders a vector the way pgvector parses it
+func vectorLiteral(vector []float32) string {
+	parts := make([]string, len(vector))
+	for i, value := range vector {
+		parts[i] = strconv.FormatFloat(float64(value), 'g', -1, 32)
+	}
+	return "[" + strings.Join(parts, ",") + "]"
+}
+
+// PendingMessages returns the newest messages of at least minLength bytes that have no vector of model yet
+func PendingMessages(model string, minLength int, limit int) ([]IndexedMessages, error) {
+	var messages []IndexedMessages
+	err := Pool.Where("LENGTH(content) >= ?", minLength).
+		Where("NOT EXISTS (SELECT 1 FROM embeddings WHERE embeddings.kind = ? AND embeddings.model = ? AND embeddings.source_id = indexed_messages.message_id)", EmbeddingMessage, model).
+		Order("id DESC").Limit(limit).Find(&messages).Error
+	return messages, err
+}
+
+// PendingFacts returns facts that have no vector of model yet
+func PendingFacts(model string, limit int) ([]UserFact, error) {
+	var facts []UserFact
+	err := Pool.Where("NOT EXISTS (SELECT 1 FROM embeddings WHERE embeddings.kind = ? AND embeddings.model = ? AND embeddings.source_id = CAST(user_facts.id AS TEXT))", EmbeddingFact, model).
+		Order("id DESC").Limit(limit).Find(&facts).Error
+	return facts, err
+}
+
+func SaveEmbeddings(embeddings []Embedding) error {
+	if len(embeddings) == 0 {
+		return nil
+	}
+
+	return Pool.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&embeddings).Error; err != nil {
+			return err
+		}
+		if !VectorIndex {
+			return nil
+		}
+
+		for _, embedding := range embeddings {
+			if embedding.ID == 0 {
+				continue
+			}
+			vector := vectorLiteral(DecodeVector(embedding.Vector))
+			if err := tx.Exec("UPDATE embeddings SET vector_pg = ?::vector WHERE id = ?", vector, embedding.ID).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// PruneEmbeddings drops vectors of deleted messages and forgotten facts
+func PruneEmbeddings() (int64, error) {
+	messages := Pool.Where("kind = ? AND NOT EXISTS (SELECT 1 FROM indexed_messages WHERE indexed_messages.message_id = embeddings.source_id AND indexed_messages.deleted_at IS NULL)", EmbeddingMessage).Delete(&Embedding{})
+	if messages.Error != nil {
+		return 0, messages.Error
+	}
+
+	facts := Pool.Where("kind = ? AND NOT EXISTS (SELECT 1 FROM user_facts WHERE CAST(user_facts.id AS TEXT) = embeddings.source_id)", EmbeddingFact).Delete(&Embedding{})
+	return messages.RowsAffected + facts.RowsAffected, facts.Error
+}
+
+// LoadEmbeddings returns vectors with an ID above afterID, for indexes that
+// load once and then only pick up new rows. Facts ignore guildID.
+func LoadEmbeddings(kind string, model string, guildID string, afterID uint) ([]Embedding, error) {
+	query := Pool.Where("kind = ? AND model = ? AND id > ?", kind, model, afterID)
+	if kind == EmbeddingMessage {
+		query = query.Where("guild_id = ?", guildID)
+	}
+
+	var embeddings []Embedding
+	err := query.Order("id").Find(&embeddings).Error
+	return embeddings, err
+}
+
+// NearestEmbeddings asks pgvector for the vectors closest to vector
+func NearestEmbeddings(kind string, model string, guildID string, vector []float32, limit int) ([]ScoredEmbedding, error) {
+	cast := "::vector(" + strconv.Itoa(len(vector)) + ")"
+	distance := "(vector_pg" + cast + ") <=> ?" + cast
+	literal := vectorLiteral(vector)
+
+	query := Pool.Model(&Embedding{}).
+		Select("id, kind, source_id, model, guild_id, channel_id, author_id, dimensions, created_at, 1 - ("+distance+") AS similarity", literal).
+		Where("kind = ? AND model = ? AND dimensions = ? AND vector_pg IS NOT NULL", kind, model, len(vector))
+	if kind == EmbeddingMessage {
+		query = query.Where("guild_id = ?", guildID)
+	}
+
+	var scored []ScoredEmbedding
+	err := query.Order(clause.Expr{SQL: distance, Vars: []any{literal}}).Limit(limit).Scan(&scored).Error
+	return scored, err
+}
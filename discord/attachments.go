@@ -0,0 +1,87 @@
+package discord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/DHCPCD9/go-swaga-bot/attachments"
+	"github.com/DHCPCD9/go-swaga-bot/configuration"
+	"github.com/DHCPCD9/go-swaga-bot/llm"
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+// attachmentParts downloads an attachment of the current message and turns it into
+// parts the provider understands, following the attachment policy
+func attachmentParts(ctx context.Context, attachment *discordgo.MessageAttachment) ([]llm.Part, error) {
+	mode, err := attachments.Check(llm.Default, attachment.ContentType, int64(attachment.Size))
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := attachments.Download(ctx, attachment.URL, configuration.Config.Attachments.MaxUploadSize)
+	if err != nil {
+		return nil, err
+	}
+
+	label := llm.TextPart(fmt.Sprintf("Attachment %s (%s):", attachment.ID, attachment.Filename))
+	mediaType := attachments.MediaType(attachment.ContentType)
+
+	switch mode {
+	case attachments.ModeText:
+		return []llm.Part{label, llm.TextPart(attachments.ExtractText(data, configuration.Config.Attachments.MaxTextLength))}, nil
+	case attachments.ModeUpload:
+		uploader, ok := llm.Default.(llm.FileUploader)
+		if !ok {
+			return nil, attachments.ErrTooLarge
+		}
+		part, err := uploader.UploadFile(ctx, attachment.Filename, mediaType, data)
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("Uploaded attachment %s (%d bytes) to %s", attachment.Filename, len(data), llm.Default.Name())
+		return []llm.Part{label, part}, nil
+	default:
+		return []llm.Part{label, {MimeType: mediaType, Data: data}}, nil
+	}
+}
+
+// attachmentNotice explains users why the bot didn't look at a file
+func attachmentNotice(filename string, err error) string {
+	switch {
+	case errors.Is(err, attachments.ErrUnsupportedType):
+		return fmt.Sprintf("Файл `%s` я открывать не буду, я такое не понимаю", filename)
+	case errors.Is(err, attachments.ErrTooLarge):
+		return fmt.Sprintf("Файл `%s` слишком огромный, я на него даже смотреть не стану", filename)
+	default:
+		return fmt.Sprintf("Файл `%s` не скачался, так что я его не видела", filename)
+	}
+}
+
+// currentAttachmentParts collects parts of every usable attachment; skipped ones are
+// mentioned to the model and to the user, so nobody pretends the file was seen
+func currentAttachmentParts(ctx context.Context, s *discordgo.Session, m *discordgo.Message) []llm.Part {
+	var parts []llm.Part
+	var notices []string
+
+	for _, attachment := range m.Attachments {
+		found, err := attachmentParts(ctx, attachment)
+		if err != nil {
+			log.Warnf("Skipping attachment %s (%s, %d bytes): %v", attachment.Filename, attachment.ContentType, attachment.Size, err)
+			notices = append(notices, attachmentNotice(attachment.Filename, err))
+			parts = append(parts, llm.TextPart(fmt.Sprintf("Attachment %s (%s) could not be shown to you: %v", attachment.ID, attachment.Filename, err)))
+			continue
+		}
+		parts = append(parts, found...)
+	}
+
+	if len(notices) > 0 {
+		if _, err := s.ChannelMessageSendReply(m.ChannelID, strings.Join(notices, "\n"), m.Reference()); err != nil {
+			log.Errorf("Failed to send attachment notice to channel %s: %v", m.ChannelID, err)
+		}
+	}
+
+	return parts
+}
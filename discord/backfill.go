@@ -0,0 +1,316 @@
+package discord
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DHCPCD9/go-swaga-bot/configuration"
+	"github.com/DHCPCD9/go-swaga-bot/database"
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+// Discord returns at most 100 messages per page, discordgo waits out rate
+// limits on its own, the delay just keeps us from hogging the bucket
+const (
+	backfillPageSize  = 100
+	backfillPageDelay = 500 * time.Millisecond
+)
+
+type BackfillOptions struct {
+	GuildIDs   []string
+	ChannelIDs []string
+	// Restart ignores checkpoints and imports channels from the newest message again
+	Restart bool
+	// Limit stops a channel after that many pages, zero means no limit
+	Limit int
+}
+
+// backfillProgress is called after every page
+type backfillProgress func(channel *discordgo.Channel, checkpoint *database.BackfillCheckpoints)
+
+// Channels being backfilled right now, so two commands don't fight over one checkpoint
+var runningBackfills sync.Map
+
+func init() {
+	permissions := int64(discordgo.PermissionManageServer)
+	dmPermission := false
+
+	registerCommand(&command{
+		definition: &discordgo.ApplicationCommand{
+			Name:                     "backfill",
+			Description:              "Загрузить старые сообщения в память Сваги",
+			DefaultMemberPermissions: &permissions,
+			DMPermission:             &dmPermission,
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:         discordgo.ApplicationCommandOptionChannel,
+					Name:         "channel",
+					Description:  "Канал, по умолчанию текущий",
+					ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText, discordgo.ChannelTypeGuildNews},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Name:        "all-channels",
+					Description: "Все каналы сервера, где разрешена индексация",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Name:        "restart",
+					Description: "Начать заново, не продолжая с прошлого раза",
+				},
+			},
+		},
+		handler: handleBackfillCommand,
+	})
+}
+
+// RunBackfill imports channel history from the command line. It only needs the
+// REST API, so no gateway connection is opened and nobody gets answered.
+func RunBackfill(ctx context.Context, options BackfillOptions) error {
+	if configuration.Config == nil {
+		return fmt.Errorf("configuration not loaded")
+	}
+
+	if database.Pool == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	session, err := discordgo.New("Bot " + configuration.Config.Discord.Token)
+	if err != nil {
+		return fmt.Errorf("error creating Discord session: %w", err)
+	}
+
+	channelIDs, skipped, err := backfillChannels(session, options)
+	if err != nil {
+		return err
+	}
+	for _, channelID := range skipped {
+		log.Warnf("Skipping channel %s, it is not indexed", channelID)
+	}
+
+	progress := func(channel *discordgo.Channel, checkpoint *database.BackfillCheckpoints) {
+		log.Infof("Backfill of #%s: %d messages indexed, done: %t", channel.Name, checkpoint.Indexed, checkpoint.Done)
+	}
+
+	for _, channelID := range channelIDs {
+		if err := backfillChannel(ctx, session, channelID, options, progress); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// backfillChannels resolves the channels to import: the given ones plus every
+// text channel of the given guilds, skipped are the given ones that may not be indexed
+func backfillChannels(s *discordgo.Session, options BackfillOptions) (channelIDs []string, skipped []string, err error) {
+	for _, channelID := range options.ChannelIDs {
+		channel, err := stateChannel(s, channelID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error getting channel %s: %w", channelID, err)
+		}
+		if channelAllowed(s, channel.GuildID, channelID, scopeIndex) {
+			channelIDs = append(channelIDs, channelID)
+		} else {
+			skipped = append(skipped, channelID)
+		}
+	}
+
+	for _, guildID := range options.GuildIDs {
+		if _, err := stateGuild(s, guildID); err != nil {
+			return nil, nil, fmt.Errorf("error getting guild %s: %w", guildID, err)
+		}
+
+		channels, err := s.GuildChannels(guildID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error listing channels of guild %s: %w", guildID, err)
+		}
+
+		for _, channel := range channels {
+			if channel.Type != discordgo.ChannelTypeGuildText && channel.Type != discordgo.ChannelTypeGuildNews {
+				continue
+			}
+			if err := s.State.ChannelAdd(channel); err != nil {
+				log.Debugf("Failed to cache channel %s: %v", channel.ID, err)
+			}
+			if channelAllowed(s, guildID, channel.ID, scopeIndex) {
+				channelIDs = append(channelIDs, channel.ID)
+			}
+		}
+	}
+
+	return channelIDs, skipped, nil
+}
+
+// backfillChannel pages backwards through a channel history starting where the
+// last run stopped, saving the checkpoint after every page
+func backfillChannel(ctx context.Context, s *discordgo.Session, channelID string, options BackfillOptions, progress backfillProgress) error {
+	if _, running := runningBackfills.LoadOrStore(channelID, true); running {
+		return fmt.Errorf("channel %s is already being backfilled", channelID)
+	}
+	defer runningBackfills.Delete(channelID)
+
+	channel, err := stateChannel(s, channelID)
+	if err != nil {
+		return fmt.Errorf("error getting channel %s: %w", channelID, err)
+	}
+
+	checkpoint, err := database.GetBackfillCheckpoint(channelID)
+	if err != nil {
+		return fmt.Errorf("error loading checkpoint of channel %s: %w", channelID, err)
+	}
+	if options.Restart {
+		checkpoint = &database.BackfillCheckpoints{ChannelID: channelID}
+	}
+	checkpoint.GuildID = channel.GuildID
+
+	if checkpoint.Done {
+		log.Infof("Channel #%s is already backfilled", channel.Name)
+		progress(channel, checkpoint)
+		return nil
+	}
+
+	log.Infof("Backfilling channel #%s", channel.Name)
+	for page := 0; options.Limit == 0 || page < options.Limit; page++ {
+		messages, err := s.ChannelMessages(channelID, backfillPageSize, checkpoint.BeforeID, "", "", discordgo.WithContext(ctx))
+		if err != nil {
+			return fmt.Errorf("error fetching messages of channel %s: %w", channelID, err)
+		}
+
+		rows := make([]*database.IndexedMessages, 0, len(messages))
+		for _, message := range messages {
+			if message.Author == nil || isOptedOut(message.Author.ID) {
+				continue
+			}
+
+			row, err := newIndexedMessage(s, message)
+			if err != nil {
+				return err
+			}
+			rows = append(rows, row)
+		}
+
+		indexed, err := database.IndexMessages(rows)
+		if err != nil {
+			return fmt.Errorf("error indexing messages of channel %s: %w", channelID, err)
+		}
+		for _, row := range rows {
+			processAttachments(ctx, row.Attachments)
+		}
+
+		checkpoint.Indexed += indexed
+		checkpoint.Done = len(messages) < backfillPageSize
+		if len(messages) > 0 {
+			//Pages come newest first
+			checkpoint.BeforeID = messages[len(messages)-1].ID
+		}
+
+		if err := database.SaveBackfillCheckpoint(checkpoint); err != nil {
+			return fmt.Errorf("error saving checkpoint of channel %s: %w", channelID, err)
+		}
+
+		progress(channel, checkpoint)
+		if checkpoint.Done {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backfillPageDelay):
+		}
+	}
+
+	return nil
+}
+
+func handleBackfillCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	options := BackfillOptions{}
+	allChannels := false
+
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case "channel":
+			options.ChannelIDs = append(options.ChannelIDs, option.Value.(string))
+		case "all-channels":
+			allChannels = option.BoolValue()
+		case "restart":
+			options.Restart = option.BoolValue()
+		}
+	}
+
+	if allChannels {
+		options.GuildIDs = []string{i.GuildID}
+	} else if len(options.ChannelIDs) == 0 {
+		options.ChannelIDs = []string{i.ChannelID}
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral},
+	})
+	if err != nil {
+		log.Errorf("Failed to respond to interaction %s: %v", i.ID, err)
+		return
+	}
+
+	log.Infof("%s started a backfill in guild %s", interactionUser(i).Username, i.GuildID)
+	go runBackfillCommand(s, i, options)
+}
+
+func runBackfillCommand(s *discordgo.Session, i *discordgo.InteractionCreate, options BackfillOptions) {
+	status := func(content string) {
+		//Interaction tokens expire after 15 minutes, after that only the log knows
+		if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &content}); err != nil {
+			log.Debugf("Failed to update backfill status: %v", err)
+		}
+	}
+
+	channelIDs, skipped, err := backfillChannels(s, options)
+	if err != nil {
+		log.Errorf("Failed to resolve backfill channels: %v", err)
+		status("Не смогла получить список каналов")
+		return
+	}
+
+	skippedNote := ""
+	if len(skipped) > 0 {
+		mentions := make([]string, 0, len(skipped))
+		for _, channelID := range skipped {
+			mentions = append(mentions, "<#"+channelID+">")
+		}
+		skippedNote = "\nНе читаю " + strings.Join(mentions, ", ") + ", их запрещено запоминать"
+	}
+	if len(channelIDs) == 0 {
+		status("Читать нечего" + skippedNote)
+		return
+	}
+
+	total := int64(0)
+	lastUpdate := time.Time{}
+	for n, channelID := range channelIDs {
+		progress := func(channel *discordgo.Channel, checkpoint *database.BackfillCheckpoints) {
+			log.Infof("Backfill of #%s: %d messages indexed, done: %t", channel.Name, checkpoint.Indexed, checkpoint.Done)
+			if time.Since(lastUpdate) > 5*time.Second || checkpoint.Done {
+				lastUpdate = time.Now()
+				status(fmt.Sprintf("Читаю <#%s> (%d/%d): %d сообщений", channel.ID, n+1, len(channelIDs), checkpoint.Indexed) + skippedNote)
+			}
+		}
+
+		if err := backfillChannel(context.Background(), s, channelID, options, progress); err != nil {
+			log.Errorf("Backfill of channel %s failed: %v", channelID, err)
+			status(fmt.Sprintf("Сломалась на <#%s>, запусти ещё раз и я продолжу с того же места", channelID))
+			return
+		}
+
+		if checkpoint, err := database.GetBackfillCheckpoint(channelID); err == nil {
+			total += checkpoint.Indexed
+		}
+	}
+
+	status(fmt.Sprintf("Готово, прочитала %d каналов, в памяти %d сообщений из них", len(channelIDs), total) + skippedNote)
+}
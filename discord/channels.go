@@ -0,0 +1,268 @@
+package discord
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/DHCPCD9/go-swaga-bot/configuration"
+	"github.com/DHCPCD9/go-swaga-bot/database"
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	scopeIndex = "index"
+	scopeReply = "reply"
+
+	actionAllow = "allow"
+	actionDeny  = "deny"
+)
+
+// Runtime rules are read on every message, so they are cached per guild and
+// dropped whenever /channels changes them
+var channelRuleCache = struct {
+	sync.RWMutex
+	rules map[string][]database.ChannelRule
+}{rules: map[string][]database.ChannelRule{}}
+
+func init() {
+	scopeOption := &discordgo.ApplicationCommandOption{
+		Type:        discordgo.ApplicationCommandOptionString,
+		Name:        "scope",
+		Description: "Что разрешить или запретить",
+		Required:    true,
+		Choices: []*discordgo.ApplicationCommandOptionChoice{
+			{Name: "Индексация сообщений", Value: scopeIndex},
+			{Name: "Ответы", Value: scopeReply},
+		},
+	}
+	targetOption := &discordgo.ApplicationCommandOption{
+		Type:        discordgo.ApplicationCommandOptionChannel,
+		Name:        "target",
+		Description: "Канал или категория",
+		Required:    true,
+		ChannelTypes: []discordgo.ChannelType{
+			discordgo.ChannelTypeGuildText,
+			discordgo.ChannelTypeGuildNews,
+			discordgo.ChannelTypeGuildForum,
+			discordgo.ChannelTypeGuildCategory,
+			discordgo.ChannelTypeGuildPublicThread,
+			discordgo.ChannelTypeGuildPrivateThread,
+		},
+	}
+	permissions := int64(discordgo.PermissionManageChannels)
+	dmPermission := false
+
+	registerCommand(&command{
+		definition: &discordgo.ApplicationCommand{
+			Name:                     "channels",
+			Description:              "Где Свага читает и отвечает",
+			DefaultMemberPermissions: &permissions,
+			DMPermission:             &dmPermission,
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        actionAllow,
+					Description: "Разрешить в канале или категории",
+					Options:     []*discordgo.ApplicationCommandOption{scopeOption, targetOption},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        actionDeny,
+					Description: "Запретить в канале или категории",
+					Options:     []*discordgo.ApplicationCommandOption{scopeOption, targetOption},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "reset",
+					Description: "Убрать правило, вернуть настройку из конфига",
+					Options:     []*discordgo.ApplicationCommandOption{scopeOption, targetOption},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "list",
+					Description: "Показать правила сервера",
+				},
+			},
+		},
+		handler: handleChannelsCommand,
+	})
+}
+
+// channelAllowed decides whether the bot may index or reply in a channel. The
+// most specific rule wins: thread, channel, then category. Runtime rules win
+// over config.yml. Without any matching rule the default applies: as soon as a
+// guild has allow rules for a scope only allowed channels pass, otherwise
+// replies are allowed and indexing follows index-all-channels.
+func channelAllowed(s *discordgo.Session, guildID string, channelID string, scope string) bool {
+	rules := guildChannelRules(guildID)
+	config := configuration.Config.Discord.Channels[guildID]
+
+	for _, target := range channelLineage(s, channelID) {
+		if action, ok := channelRuleFor(rules, config, target, scope); ok {
+			return action == actionAllow
+		}
+	}
+
+	if hasAllowRules(rules, config, scope) {
+		return false
+	}
+
+	if scope == scopeIndex {
+		return configuration.Config.Discord.IndexAllChannels
+	}
+	return true
+}
+
+// channelLineage returns the channel ID followed by its parents, a thread has
+// its channel as parent and the channel its category
+func channelLineage(s *discordgo.Session, channelID string) []string {
+	lineage := []string{channelID}
+
+	for len(lineage) < 3 {
+		channel, err := stateChannel(s, lineage[len(lineage)-1])
+		if err != nil || channel.ParentID == "" {
+			break
+		}
+		lineage = append(lineage, channel.ParentID)
+	}
+
+	return lineage
+}
+
+func channelRuleFor(rules []database.ChannelRule, config configuration.GuildChannels, target string, scope string) (string, bool) {
+	for _, rule := range rules {
+		if rule.TargetID == target && rule.Scope == scope {
+			return rule.Action, true
+		}
+	}
+
+	configRules := config.Index
+	if scope == scopeReply {
+		configRules = config.Reply
+	}
+
+	if slices.Contains(configRules.Deny, target) {
+		return actionDeny, true
+	}
+	if slices.Contains(configRules.Allow, target) {
+		return actionAllow, true
+	}
+
+	return "", false
+}
+
+func hasAllowRules(rules []database.ChannelRule, config configuration.GuildChannels, scope string) bool {
+	for _, rule := range rules {
+		if rule.Scope == scope && rule.Action == actionAllow {
+			return true
+		}
+	}
+
+	if scope == scopeReply {
+		return len(config.Reply.Allow) > 0
+	}
+	return len(config.Index.Allow) > 0
+}
+
+func guildChannelRules(guildID string) []database.ChannelRule {
+	if guildID == "" {
+		return nil
+	}
+
+	channelRuleCache.RLock()
+	rules, ok := channelRuleCache.rules[guildID]
+	channelRuleCache.RUnlock()
+	if ok {
+		return rules
+	}
+
+	rules, err := database.ListChannelRules(guildID)
+	if err != nil {
+		log.Errorf("Failed to load channel rules of guild %s: %v", guildID, err)
+		return nil
+	}
+
+	channelRuleCache.Lock()
+	channelRuleCache.rules[guildID] = rules
+	channelRuleCache.Unlock()
+
+	return rules
+}
+
+func invalidateChannelRules(guildID string) {
+	channelRuleCache.Lock()
+	delete(channelRuleCache.rules, guildID)
+	channelRuleCache.Unlock()
+}
+
+func handleChannelsCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	option := subcommand(i)
+	if option == nil || i.GuildID == "" {
+		return
+	}
+
+	if option.Name == "list" {
+		respondEphemeral(s, i, describeChannelRules(i.GuildID))
+		return
+	}
+
+	scope := option.GetOption("scope").StringValue()
+	target := option.GetOption("target").Value.(string)
+
+	switch option.Name {
+	case actionAllow, actionDeny:
+		if err := database.SetChannelRule(i.GuildID, target, scope, option.Name); err != nil {
+			log.Errorf("Failed to save channel rule for %s in guild %s: %v", target, i.GuildID, err)
+			respondEphemeral(s, i, "Не получилось сохранить правило")
+			return
+		}
+		log.Infof("%s set %s %s for %s in guild %s", interactionUser(i).Username, scope, option.Name, target, i.GuildID)
+	case "reset":
+		if _, err := database.DeleteChannelRule(i.GuildID, target, scope); err != nil {
+			log.Errorf("Failed to delete channel rule for %s in guild %s: %v", target, i.GuildID, err)
+			respondEphemeral(s, i, "Не получилось убрать правило")
+			return
+		}
+		log.Infof("%s reset %s rule for %s in guild %s", interactionUser(i).Username, scope, target, i.GuildID)
+	}
+
+	invalidateChannelRules(i.GuildID)
+	respondEphemeral(s, i, describeChannelRules(i.GuildID))
+}
+
+func describeChannelRules(guildID string) string {
+	var lines []string
+
+	for _, rule := range guildChannelRules(guildID) {
+		lines = append(lines, fmt.Sprintf("• <#%s>: %s %s", rule.TargetID, rule.Scope, rule.Action))
+	}
+
+	config := configuration.Config.Discord.Channels[guildID]
+	lines = append(lines, describeConfigRules(scopeIndex, config.Index)...)
+	lines = append(lines, describeConfigRules(scopeReply, config.Reply)...)
+
+	if len(lines) == 0 {
+		if configuration.Config.Discord.IndexAllChannels {
+			return "Правил нет, читаю и отвечаю везде"
+		}
+		return "Правил нет, отвечаю везде, но ничего не индексирую"
+	}
+
+	return truncate("Правила сервера:\n"+strings.Join(lines, "\n"), 2000)
+}
+
+func describeConfigRules(scope string, rules configuration.ChannelRules) []string {
+	var lines []string
+
+	for _, target := range rules.Allow {
+		lines = append(lines, fmt.Sprintf("• <#%s>: %s %s (config.yml)", target, scope, actionAllow))
+	}
+	for _, target := range rules.Deny {
+		lines = append(lines, fmt.Sprintf("• <#%s>: %s %s (config.yml)", target, scope, actionDeny))
+	}
+
+	return lines
+}
@@ -0,0 +1,127 @@
+package discord
+
+import (
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+type commandHandler func(s *discordgo.Session, i *discordgo.InteractionCreate)
+
+// command is a slash command with its handlers, autocomplete is optional
+type command struct {
+	definition   *discordgo.ApplicationCommand
+	handler      commandHandler
+	autocomplete commandHandler
+}
+
+var commands = map[string]*command{}
+
+func registerCommand(c *command) {
+	commands[c.definition.Name] = c
+}
+
+// registerCommands replaces all global application commands with ours
+func registerCommands(s *discordgo.Session) {
+	definitions := make([]*discordgo.ApplicationCommand, 0, len(commands))
+	for _, c := range commands {
+		definitions = append(definitions, c.definition)
+	}
+
+	registered, err := s.ApplicationCommandBulkOverwrite(s.State.User.ID, "", definitions)
+	if err != nil {
+		log.Errorf("Failed to register application commands: %v", err)
+		return
+	}
+
+	log.Infof("Registered %d application commands", len(registered))
+}
+
+func handleInteraction(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionApplicationCommand && i.Type != discordgo.InteractionApplicationCommandAutocomplete {
+		return
+	}
+
+	data := i.ApplicationCommandData()
+	c, ok := commands[data.Name]
+	if !ok {
+		log.Warnf("Received unknown command %s", data.Name)
+		return
+	}
+
+	if i.Type == discordgo.InteractionApplicationCommandAutocomplete {
+		if c.autocomplete != nil {
+			c.autocomplete(s, i)
+		}
+		return
+	}
+
+	log.Infof("User %s used /%s", interactionUser(i).Username, data.Name)
+	c.handler(s, i)
+}
+
+// interactionUser returns who triggered the interaction, in guilds and DMs alike
+func interactionUser(i *discordgo.InteractionCreate) *discordgo.User {
+	if i.Member != nil {
+		return i.Member.User
+	}
+	return i.User
+}
+
+// subcommand returns the used subcommand, nil if there is none
+func subcommand(i *discordgo.InteractionCreate) *discordgo.ApplicationCommandInteractionDataOption {
+	options := i.ApplicationCommandData().Options
+	if len(options) == 0 || options[0].Type != discordgo.ApplicationCommandOptionSubCommand {
+		return nil
+	}
+	return options[0]
+}
+
+// focusedOption returns the option being autocompleted
+func focusedOption(options []*discordgo.ApplicationCommandInteractionDataOption) *discordgo.ApplicationCommandInteractionDataOption {
+	for _, option := range options {
+		if option.Focused {
+			return option
+		}
+		if focused := focusedOption(option.Options); focused != nil {
+			return focused
+		}
+	}
+	return nil
+}
+
+func respondEphemeral(s *discordgo.Session, i *discordgo.InteractionCreate, content string) {
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		log.Errorf("Failed to respond to interaction %s: %v", i.ID, err)
+	}
+}
+
+func respondChoices(s *discordgo.Session, i *discordgo.InteractionCreate, choices []*discordgo.ApplicationCommandOptionChoice) {
+	//Discord accepts at most 25 choices
+	if len(choices) > 25 {
+		choices = choices[:25]
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{Choices: choices},
+	})
+	if err != nil {
+		log.Errorf("Failed to send autocomplete choices for interaction %s: %v", i.ID, err)
+	}
+}
+
+// truncate cuts text to at most limit runes, Discord limits most strings
+func truncate(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit-1]) + "…"
+}
@@ -1,15 +1,16 @@
 package discord
 
 import (
-	"encoding/base64"
+	"context"
 	"encoding/json"
 	"fmt"
 	"strconv"
-	"strings"
+	"time"
 
 	"github.com/DHCPCD9/go-swaga-bot/configuration"
+	"github.com/DHCPCD9/go-swaga-bot/conversation"
 	"github.com/DHCPCD9/go-swaga-bot/database"
-	"github.com/DHCPCD9/go-swaga-bot/gemini"
+	"github.com/DHCPCD9/go-swaga-bot/llm"
 	"github.com/bwmarrin/discordgo"
 	log "github.com/sirupsen/logrus"
 )
@@ -26,6 +27,10 @@ func Init() error {
 		return fmt.Errorf("database not initialized")
 	}
 
+	if llm.Default == nil {
+		return fmt.Errorf("llm provider not initialized")
+	}
+
 	discord, err := discordgo.New("Bot " + configuration.Config.Discord.Token)
 
 	if err != nil {
@@ -37,6 +42,10 @@ func Init() error {
 
 	discord.AddHandler(handleReady)
 	discord.AddHandler(handleMessage)
+	discord.AddHandler(handleMessageUpdate)
+	discord.AddHandler(handleMessageDelete)
+	discord.AddHandler(handleMessageDeleteBulk)
+	discord.AddHandler(handleInteraction)
 	err = discord.Open()
 
 	if err != nil {
@@ -51,6 +60,8 @@ func handleReady(s *discordgo.Session, event *discordgo.Ready) {
 	s.UpdateCustomStatus("Listening to you~")
 	log.Infof("Logged in as %s#%s", event.User.Username, event.User.Discriminator)
 
+	registerCommands(s)
+
 	var count int64
 	database.Pool.Model(&database.IndexedMessages{}).Count(&count)
 	log.Infof("Indexed %d messages in the database", count)
@@ -60,34 +71,27 @@ func handleMessage(s *discordgo.Session, m *discordgo.MessageCreate) {
 
 	log.Infof("Received message from %s: %s", m.Author.Username, m.Content)
 
-	channel, err := s.State.Channel(m.ChannelID)
+	indexedMessage, err := newIndexedMessage(s, m.Message)
 	if err != nil {
-		log.Errorf("Failed to get channel %s: %v", m.ChannelID, err)
 		return
 	}
 
-	guild, err := s.State.Guild(channel.GuildID)
-	if err != nil {
-		log.Errorf("Failed to get guild %s: %v", channel.GuildID, err)
+	//Direct messages don't need a mention, but can be turned off globally or per user
+	isDM := indexedMessage.GuildID == ""
+	if isDM && m.Author.ID != s.State.User.ID && !directMessagesEnabled(m.Author.ID) {
+		log.Debugf("Ignoring direct message %s, %s disabled direct messages", m.ID, m.Author.Username)
 		return
 	}
 
-	indexedMessage := database.IndexedMessages{
-		MessageID:   m.ID,
-		Content:     m.Content,
-		ChannelID:   m.ChannelID,
-		ChannelName: channel.Name,
-		GuildID:     m.GuildID,
-		GuildName:   guild.Name,
-		CreatedAt:   m.Timestamp.Unix(),
-		AuthorID:    m.Author.ID,
-		Username:    m.Author.Username,
-	}
-
-	if err := database.Pool.Create(&indexedMessage).Error; err != nil {
-		log.Errorf("Failed to index message %s in channel %s: %v", m.ID, channel.Name, err)
+	if !channelAllowed(s, indexedMessage.GuildID, m.ChannelID, scopeIndex) {
+		log.Debugf("Not indexing message %s, channel %s is not indexed", m.ID, indexedMessage.ChannelName)
+	} else if isOptedOut(m.Author.ID) {
+		log.Debugf("Not indexing message %s, %s opted out", m.ID, m.Author.Username)
+	} else if _, err := database.IndexMessages([]*database.IndexedMessages{indexedMessage}); err != nil {
+		log.Errorf("Failed to index message %s in channel %s: %v", m.ID, indexedMessage.ChannelName, err)
 	} else {
-		log.Infof("Indexed message %s in channel %s", m.ID, channel.Name)
+		log.Infof("Indexed message %s in channel %s", m.ID, indexedMessage.ChannelName)
+		go processAttachments(context.Background(), indexedMessage.Attachments)
 	}
 
 	isMeMentioned := false
@@ -115,31 +119,23 @@ func handleMessage(s *discordgo.Session, m *discordgo.MessageCreate) {
 	//     "references": [{"id": <id>, "text": "text", "user": <user_id>}],
 	//     "reference_users": [{"id": <user_id>, "username": "<username>", "known_names": ["name1", "name2"], "facts": ["fact1", "fact2"]}],
 	// }
-	if isMeMentioned && m.Author.ID != s.State.User.ID {
+	if (isMeMentioned || isDM) && m.Author.ID != s.State.User.ID && channelAllowed(s, indexedMessage.GuildID, m.ChannelID, scopeReply) {
+		if wait, err := checkLimits(m, indexedMessage.GuildID); err != nil {
+			log.Infof("Not answering %s in channel %s: %v", m.Author.Username, indexedMessage.ChannelName, err)
+			if limits.shouldNotify(m.Author.ID, wait, time.Now()) {
+				if _, err := s.ChannelMessageSendReply(m.ChannelID, limitReply(err, wait), m.Reference()); err != nil {
+					log.Errorf("Failed to send message to channel %s: %v", m.ChannelID, err)
+				}
+			}
+			return
+		}
+
 		s.ChannelTyping(m.ChannelID)
 
-		parts := gemini.BuildParts(m.Author.ID, m.GuildID)
+		ctx, cancel := context.WithTimeout(context.Background(), configuration.Config.LLM.Timeout)
+		defer cancel()
 
-		for _, attachment := range m.Attachments {
-			// if attachment.ContentType != "" && strings.HasPrefix(attachment.ContentType, "image/") {
-			//Downloading from discord and converting image to base64
-			data, err := s.Request("GET", attachment.URL, nil)
-			if err != nil {
-				log.Errorf("Failed to download image %s: %v", attachment.URL, err)
-				continue
-			}
-			b64Image := base64.StdEncoding.EncodeToString(data)
-			parts.Parts = append(parts.Parts, gemini.Parts{
-				InlineData: &struct {
-					MimeType string "json:\"mime_type\""
-					Data     string "json:\"data\""
-				}{
-					MimeType: attachment.ContentType,
-					Data:     b64Image,
-				},
-			})
-			// }
-		}
+		parts := currentAttachmentParts(ctx, s, m.Message)
 
 		// baseText := fmt.Sprintf("<@%s> Asked: %s", m.Author.ID, m.Content)
 		var dbUser database.KnownUsers
@@ -149,9 +145,9 @@ func handleMessage(s *discordgo.Session, m *discordgo.MessageCreate) {
 		var names []database.UserName
 
 		database.Pool.First(&dbUser, "id = ?", parsedID)
-		database.Pool.Find(&facts, "user_id = ?", parsedID)
+		facts = userFacts(m.Author.ID)
 		database.Pool.Find(&names, "user_id = ?", parsedID)
-		basePrompt := gemini.PromptJson{
+		basePrompt := conversation.PromptJson{
 			UserID:     m.Author.ID,
 			Username:   m.Author.Username,
 			Text:       m.Content,
@@ -161,8 +157,8 @@ func handleMessage(s *discordgo.Session, m *discordgo.MessageCreate) {
 				State    string "json:\"state\""
 				Substate string "json:\"substate\""
 			}, 0),
-			Facts:     database.FactsToStrings(facts),
-			Reference: m.Reference().MessageID,
+			Facts:     database.FactsToStrings(conversation.FitFacts(facts, configuration.Config.Context.FactsBudget)),
+			Reference: referenceID(m.Message),
 			References: make([]struct {
 				ID   string "json:\"id\""
 				Text string "json:\"text\""
@@ -179,7 +175,7 @@ func handleMessage(s *discordgo.Session, m *discordgo.MessageCreate) {
 		presences, err := s.State.Presence(m.GuildID, m.Author.ID)
 
 		log.Debugf("Presence for %s: %+v", m.Author.ID, presences)
-		if err != nil {
+		if err != nil && !isDM {
 			log.Errorf("Failed to get presence for user %s: %v", m.Author.ID, err)
 		}
 
@@ -209,7 +205,7 @@ func handleMessage(s *discordgo.Session, m *discordgo.MessageCreate) {
 		for _, mention := range m.Mentions {
 			presences, err := s.State.Presence(m.GuildID, mention.ID)
 			log.Debugf("Presence for %s: %+v", mention.ID, presences)
-			if err != nil {
+			if err != nil && !isDM {
 				log.Errorf("Failed to get presence for user %s: %v", mention.ID, err)
 			}
 
@@ -219,7 +215,7 @@ func handleMessage(s *discordgo.Session, m *discordgo.MessageCreate) {
 			var facts []database.UserFact
 			var names []database.UserName
 
-			database.Pool.Find(&facts, "user_id = ?", mention.ID)
+			facts = userFacts(mention.ID)
 			database.Pool.Find(&names, "user_id = ?", mention.ID)
 			if presences != nil {
 				basePrompt.ReferenceUsers = append(basePrompt.ReferenceUsers, struct {
@@ -231,7 +227,7 @@ func handleMessage(s *discordgo.Session, m *discordgo.MessageCreate) {
 					ID:         mention.ID,
 					Username:   mention.Username,
 					KnownNames: database.NamestToStrings(names),
-					Facts:      database.FactsToStrings(facts),
+					Facts:      database.FactsToStrings(conversation.FitFacts(facts, configuration.Config.Context.FactsBudget)),
 				})
 			} else {
 				basePrompt.ReferenceUsers = append(basePrompt.ReferenceUsers, struct {
@@ -248,70 +244,97 @@ func handleMessage(s *discordgo.Session, m *discordgo.MessageCreate) {
 			}
 		}
 
+		//Messages this one replies to, so the model knows what is being answered
+		chain := replyChain(s, m.Message)
+		for _, reference := range chain {
+			basePrompt.References = append(basePrompt.References, struct {
+				ID   string "json:\"id\""
+				Text string "json:\"text\""
+				User string "json:\"user\""
+			}{
+				ID:   reference.MessageID,
+				Text: reference.Content,
+				User: reference.AuthorID,
+			})
+
+			known := reference.AuthorID == s.State.User.ID || reference.AuthorID == m.Author.ID
+			for _, user := range basePrompt.ReferenceUsers {
+				known = known || user.ID == reference.AuthorID
+			}
+			if known {
+				continue
+			}
+
+			var facts []database.UserFact
+			var names []database.UserName
+
+			facts = userFacts(reference.AuthorID)
+			database.Pool.Find(&names, "user_id = ?", reference.AuthorID)
+			basePrompt.ReferenceUsers = append(basePrompt.ReferenceUsers, struct {
+				ID         string   "json:\"id\""
+				Username   string   "json:\"username\""
+				KnownNames []string "json:\"known_names\""
+				Facts      []string "json:\"facts\""
+			}{
+				ID:         reference.AuthorID,
+				Username:   reference.Username,
+				KnownNames: database.NamestToStrings(names),
+				Facts:      database.FactsToStrings(conversation.FitFacts(facts, configuration.Config.Context.FactsBudget)),
+			})
+		}
+
 		// baseText += "[Attachment from part above]"
 
 		var marshaledBasePrompt []byte
 		marshaledBasePrompt, err = json.Marshal(basePrompt)
 		log.Debug("Base text for Gemini request: ", string(marshaledBasePrompt))
 
-		parts.Parts = append(parts.Parts, gemini.Parts{Text: string(marshaledBasePrompt), InlineData: nil})
-
-		body := gemini.BuildBody([]gemini.Contents{*parts})
+		parts = append(parts, llm.TextPart(string(marshaledBasePrompt)))
 
 		if err != nil {
-			log.Errorf("Failed to send Gemini request: %v", err)
+			log.Errorf("Failed to marshal prompt: %v", err)
 			return
 		}
 
-		response, err := gemini.SendRequest(body)
-
-		// jsonToSave, _ := json.Marshal(response)
-
-		// os.WriteFile("gemini_request.json", jsonToSave, 0644)
+		request := conversation.Build(ctx, conversation.Input{
+			BotID:      s.State.User.ID,
+			MessageID:  m.ID,
+			UserID:     m.Author.ID,
+			GuildID:    m.GuildID,
+			ChannelID:  m.ChannelID,
+			ReplyChain: chain,
+			Question:   m.Content,
+			Visible: func(channelID string) bool {
+				return canRead(s, m.Author.ID, channelID)
+			},
+		}, parts)
+		request.ResponseSchema = conversation.ResponseSchema
+
+		response, err := generateWithTools(ctx, s, usageScope{GuildID: indexedMessage.GuildID, ChannelID: m.ChannelID, UserID: m.Author.ID}, m.ID, request)
 
 		if err != nil {
-			log.Errorf("Failed to send Gemini request: %v", err)
+			log.Errorf("Failed to send %s request: %v", llm.Default.Name(), err)
+			if _, err := s.ChannelMessageSendReply(m.ChannelID, errorReply(err), m.Reference()); err != nil {
+				log.Errorf("Failed to send message to channel %s: %v", m.ChannelID, err)
+			}
 			return
 		}
 
-		if len(response.Candidates) > 0 {
-			//Answering the first candidate
-			answer := response.Candidates[0].Content.Parts[0].Text
+		answer := response.Text
 
-			answer = strings.TrimLeft(answer, "```json")
-			answer = strings.TrimRight(answer, "```")
-			var parsedAnswer gemini.ResponseJson
-			if err = json.Unmarshal([]byte(answer), &parsedAnswer); err != nil {
-				if _, err := s.ChannelMessageSendReply(m.ChannelID, fmt.Sprintf("Failed to process message: %s", err.Error()), m.Reference()); err != nil {
-					log.Errorf("Failed to send message to channel %s: %v", m.ChannelID, err)
-				} else {
-					log.Infof("Sent response to channel %s: %s", m.ChannelID, answer)
-				}
-				return
-			}
-
-			for _, username := range parsedAnswer.Usernames {
-				if username.Type == "add" {
-					parsed, _ := strconv.Atoi(username.User)
-					database.AddUsername(uint64(parsed), username.Username)
-				}
-			}
-
-			for _, fact := range parsedAnswer.Facts {
-				parsed, _ := strconv.Atoi(fact.User)
-
-				if fact.Type == "add" {
-					database.AddFact(uint64(parsed), fact.Fact)
-				} else {
-					database.RemoveFact(uint64(parsed), fact.Fact)
-				}
-			}
-
-			if _, err := s.ChannelMessageSendReply(m.ChannelID, parsedAnswer.Response, m.Reference()); err != nil {
+		parsedAnswer, err := conversation.ParseResponse(answer)
+		if err != nil {
+			log.Errorf("Failed to parse answer %q: %v", answer, err)
+			if _, err := s.ChannelMessageSendReply(m.ChannelID, errorReply(err), m.Reference()); err != nil {
 				log.Errorf("Failed to send message to channel %s: %v", m.ChannelID, err)
-			} else {
-				log.Infof("Sent response to channel %s: %s", m.ChannelID, answer)
 			}
+			return
+		}
+
+		if err := sendReply(s, m.ChannelID, m.Reference(), parsedAnswer.Response); err != nil {
+			log.Errorf("Failed to send message to channel %s: %v", m.ChannelID, err)
+		} else {
+			log.Infof("Sent response to channel %s: %s", m.ChannelID, answer)
 		}
 	}
 }
@@ -0,0 +1,74 @@
+package discord
+
+import (
+	"strconv"
+
+	"github.com/DHCPCD9/go-swaga-bot/configuration"
+	"github.com/DHCPCD9/go-swaga-bot/database"
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+func init() {
+	registerCommand(&command{
+		definition: &discordgo.ApplicationCommand{
+			Name:        "dm",
+			Description: "Отвечать ли тебе в личке",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "enable",
+					Description: "Отвечать мне в личных сообщениях",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "disable",
+					Description: "Игнорировать мои личные сообщения",
+				},
+			},
+		},
+		handler: handleDMCommand,
+	})
+}
+
+func directMessagesEnabled(userID string) bool {
+	if !configuration.Config.Discord.DirectMessages {
+		return false
+	}
+
+	user, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return false
+	}
+	return !database.IsDMDisabled(user)
+}
+
+func handleDMCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	user, err := strconv.ParseUint(interactionUser(i).ID, 10, 64)
+	if err != nil {
+		log.Errorf("Failed to parse user ID %s: %v", interactionUser(i).ID, err)
+		return
+	}
+
+	option := subcommand(i)
+	if option == nil {
+		return
+	}
+
+	disabled := option.Name == "disable"
+	if err := database.SetDMDisabled(user, disabled); err != nil {
+		log.Errorf("Failed to change DM setting of %d: %v", user, err)
+		respondEphemeral(s, i, "Не получилось сохранить, попробуй позже")
+		return
+	}
+
+	log.Infof("User %d set DM disabled to %t", user, disabled)
+	switch {
+	case disabled:
+		respondEphemeral(s, i, "Ладно, в личке тебя игнорю")
+	case !configuration.Config.Discord.DirectMessages:
+		respondEphemeral(s, i, "Запомнила, но личку тут вообще выключили, так что пиши на сервере")
+	default:
+		respondEphemeral(s, i, "Окей, пиши в личку, отвечу. Может быть")
+	}
+}
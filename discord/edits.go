@@ -0,0 +1,54 @@
+package discord
+
+import (
+	"github.com/DHCPCD9/go-swaga-bot/database"
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+func handleMessageUpdate(s *discordgo.Session, m *discordgo.MessageUpdate) {
+	//Updates without an edit timestamp are embeds being resolved and such, not edits
+	if m.Message == nil || m.EditedTimestamp == nil {
+		return
+	}
+
+	edited, err := database.EditMessage(m.ID, m.Content, m.EditedTimestamp.Unix())
+	if err != nil {
+		log.Errorf("Failed to update message %s: %v", m.ID, err)
+		return
+	}
+
+	if edited {
+		log.Infof("Updated edited message %s in channel %s", m.ID, m.ChannelID)
+	}
+}
+
+func handleMessageDelete(s *discordgo.Session, m *discordgo.MessageDelete) {
+	deleted, err := database.DeleteMessages(m.ID)
+	if err != nil {
+		log.Errorf("Failed to delete message %s: %v", m.ID, err)
+		return
+	}
+
+	if deleted > 0 {
+		log.Infof("Deleted message %s in channel %s", m.ID, m.ChannelID)
+	}
+}
+
+func handleMessageDeleteBulk(s *discordgo.Session, m *discordgo.MessageDeleteBulk) {
+	deleted, err := database.DeleteMessages(m.Messages...)
+	if err != nil {
+		log.Errorf("Failed to delete %d messages in channel %s: %v", len(m.Messages), m.ChannelID, err)
+		return
+	}
+
+	log.Infof("Deleted %d of %d bulk deleted messages in channel %s", deleted, len(m.Messages), m.ChannelID)
+}
+
+// editedAt returns when a message was last changed, for messages that never were it's zero
+func editedAt(m *discordgo.Message) int64 {
+	if m.EditedTimestamp == nil {
+		return 0
+	}
+	return m.EditedTimestamp.Unix()
+}
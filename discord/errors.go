@@ -0,0 +1,29 @@
+package discord
+
+import (
+	"context"
+	"errors"
+
+	"github.com/DHCPCD9/go-swaga-bot/llm"
+)
+
+// errorReply picks an in-character answer for a failed model call, so users
+// see why the bot is silent instead of a raw error
+func errorReply(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "Я задумалась и чё-то слишком надолго, спроси ещё раз попозже"
+	case errors.Is(err, llm.ErrRateLimited):
+		return "Эй, полегче, меня тут все дёргают одновременно, дай отдышаться пару минут"
+	case errors.Is(err, llm.ErrQuotaExhausted):
+		return "Всё, я на сегодня выговорилась, лимит кончился. Приходи завтра, бака"
+	case errors.Is(err, llm.ErrSafetyBlocked):
+		return "Не, на такое я отвечать не буду, даже не проси"
+	case errors.Is(err, llm.ErrBadRequest):
+		return "Я вообще не поняла, что это было, попробуй сказать по-другому"
+	case errors.Is(err, llm.ErrServerError):
+		return "У меня мозги сейчас отвалились, сервер тупит, попробуй чуть позже"
+	default:
+		return "Что-то пошло не так, и это точно не моя вина"
+	}
+}
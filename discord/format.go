@@ -0,0 +1,214 @@
+package discord
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/DHCPCD9/go-swaga-bot/configuration"
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	messageLimit = 2000
+	embedLimit   = 4096
+	embedColor   = 0xF4A7C6
+	// maxFenceLength limits the fence line carried into every chunk of a split code block
+	maxFenceLength = 32
+)
+
+// segment is a piece of a message that is better not split: a paragraph or a
+// whole code block. fence holds the opening fence line of a code block.
+type segment struct {
+	text  string
+	fence string
+}
+
+// sendReply sends an answer as a reply to reference. Long answers are split on
+// paragraph and code block boundaries, very long ones go as a file.
+func sendReply(s *discordgo.Session, channelID string, reference *discordgo.MessageReference, text string) error {
+	config := configuration.Config.Discord.Responses
+
+	limit := messageLimit
+	if config.UseEmbeds {
+		limit = embedLimit
+	}
+
+	chunks := splitMessage(text, limit)
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	if config.MaxMessages > 0 && len(chunks) > config.MaxMessages {
+		log.Debugf("Answer of %d characters is too long, sending it as a file", utf8.RuneCountInString(text))
+		_, err := s.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+			Content:   "Много букв получилось, держи файлом",
+			Reference: reference,
+			Files: []*discordgo.File{{
+				Name:        "answer.md",
+				ContentType: "text/markdown",
+				Reader:      strings.NewReader(text),
+			}},
+		})
+		return err
+	}
+
+	for i, chunk := range chunks {
+		message := &discordgo.MessageSend{Content: chunk}
+		if config.UseEmbeds {
+			message = &discordgo.MessageSend{Embeds: []*discordgo.MessageEmbed{{Description: chunk, Color: embedColor}}}
+		}
+		//Only the first part is a reply, the rest just follows it
+		if i == 0 {
+			message.Reference = reference
+		}
+
+		if _, err := s.ChannelMessageSendComplex(channelID, message); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// splitMessage splits text into chunks of at most limit characters, keeping
+// paragraphs and code blocks together when possible. Code blocks that have to
+// be split are closed at the end of a chunk and reopened in the next one.
+func splitMessage(text string, limit int) []string {
+	var chunks []string
+	var current strings.Builder
+
+	flush := func() {
+		if chunk := strings.Trim(current.String(), "\n"); strings.TrimSpace(chunk) != "" {
+			chunks = append(chunks, chunk)
+		}
+		current.Reset()
+	}
+
+	for _, segment := range splitSegments(text) {
+		if runeCount(current.String())+runeCount(segment.text) <= limit {
+			current.WriteString(segment.text)
+			continue
+		}
+
+		flush()
+		if runeCount(segment.text) <= limit {
+			current.WriteString(segment.text)
+			continue
+		}
+
+		chunks = append(chunks, splitSegment(segment, limit)...)
+	}
+	flush()
+
+	return chunks
+}
+
+// splitSegments cuts text into paragraphs and code blocks, closing a code block
+// the model forgot to close
+func splitSegments(text string) []segment {
+	var segments []segment
+	var current strings.Builder
+	fence := ""
+
+	for _, line := range strings.SplitAfter(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		isFence := strings.HasPrefix(trimmed, "```") && !strings.Contains(trimmed[3:], "```")
+
+		switch {
+		case fence == "" && isFence:
+			if current.Len() > 0 {
+				segments = append(segments, segment{text: current.String()})
+				current.Reset()
+			}
+			fence = trimmed
+			current.WriteString(line)
+		case fence != "":
+			current.WriteString(line)
+			if isFence {
+				segments = append(segments, segment{text: current.String(), fence: fence})
+				current.Reset()
+				fence = ""
+			}
+		default:
+			current.WriteString(line)
+			if trimmed == "" {
+				segments = append(segments, segment{text: current.String()})
+				current.Reset()
+			}
+		}
+	}
+
+	if fence != "" {
+		segments = append(segments, segment{text: strings.TrimRight(current.String(), "\n") + "\n```", fence: fence})
+	} else if current.Len() > 0 {
+		segments = append(segments, segment{text: current.String()})
+	}
+
+	return segments
+}
+
+// splitSegment splits a single segment that doesn't fit into one chunk by lines
+func splitSegment(segment segment, limit int) []string {
+	lines := strings.SplitAfter(strings.TrimRight(segment.text, "\n"), "\n")
+	prefix, suffix := "", ""
+	fence := []rune(segment.fence)
+	if len(fence) > maxFenceLength {
+		fence = fence[:maxFenceLength]
+	}
+	//Without room for fences the block is split like plain text
+	if segment.fence != "" && limit-len(fence)-len("\n\n```") > 0 {
+		//Fences are added to every chunk, so the original ones go away
+		lines = lines[1 : len(lines)-1]
+		prefix, suffix = string(fence)+"\n", "\n```"
+	}
+	capacity := limit - runeCount(prefix) - runeCount(suffix)
+
+	var chunks []string
+	var current strings.Builder
+
+	flush := func() {
+		if current.Len() == 0 {
+			return
+		}
+		chunks = append(chunks, prefix+strings.TrimRight(current.String(), "\n")+suffix)
+		current.Reset()
+	}
+
+	for _, line := range lines {
+		for _, piece := range splitLine(line, capacity) {
+			if runeCount(current.String())+runeCount(piece) > capacity {
+				flush()
+			}
+			current.WriteString(piece)
+		}
+	}
+	flush()
+
+	return chunks
+}
+
+// splitLine cuts a line longer than limit, preferably on spaces
+func splitLine(line string, limit int) []string {
+	if limit <= 0 {
+		return []string{line}
+	}
+
+	var pieces []string
+
+	for runeCount(line) > limit {
+		runes := []rune(line)
+		cut := limit
+		if space := strings.LastIndex(string(runes[:limit]), " "); space > 0 {
+			cut = utf8.RuneCountInString(string(runes[:limit])[:space+1])
+		}
+		pieces = append(pieces, string(runes[:cut]))
+		line = string(runes[cut:])
+	}
+
+	return append(pieces, line)
+}
+
+func runeCount(text string) int {
+	return utf8.RuneCountInString(text)
+}
@@ -0,0 +1,162 @@
+package discord
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/DHCPCD9/go-swaga-bot/attachments"
+	"github.com/DHCPCD9/go-swaga-bot/database"
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+// newIndexedMessage converts a Discord message into a database row, resolving
+// channel and guild names from the state. Direct messages get an empty guild.
+func newIndexedMessage(s *discordgo.Session, m *discordgo.Message) (*database.IndexedMessages, error) {
+	channel, err := stateChannel(s, m.ChannelID)
+	if err != nil {
+		log.Errorf("Failed to get channel %s: %v", m.ChannelID, err)
+		return nil, err
+	}
+
+	channelName := channel.Name
+	guildName := ""
+	if channel.GuildID != "" {
+		guild, err := stateGuild(s, channel.GuildID)
+		if err != nil {
+			log.Errorf("Failed to get guild %s: %v", channel.GuildID, err)
+			return nil, err
+		}
+		guildName = guild.Name
+	} else if channelName == "" {
+		channelName = "DM"
+	}
+
+	return &database.IndexedMessages{
+		MessageID:   m.ID,
+		Content:     m.Content,
+		ChannelID:   m.ChannelID,
+		ChannelName: channelName,
+		GuildID:     channel.GuildID,
+		GuildName:   guildName,
+		CreatedAt:   m.Timestamp.Unix(),
+		EditedAt:    editedAt(m),
+		AuthorID:    m.Author.ID,
+		Username:    m.Author.Username,
+
+		ReferenceMessageID: referenceID(m),
+		Attachments:        newIndexedAttachments(m),
+	}, nil
+}
+
+func newIndexedAttachments(m *discordgo.Message) []database.IndexedAttachments {
+	rows := make([]database.IndexedAttachments, 0, len(m.Attachments))
+
+	for _, attachment := range m.Attachments {
+		rows = append(rows, database.IndexedAttachments{
+			AttachmentID: attachment.ID,
+			MessageID:    m.ID,
+			AuthorID:     m.Author.ID,
+			Filename:     attachment.Filename,
+			ContentType:  attachment.ContentType,
+			Size:         int64(attachment.Size),
+			URL:          attachment.URL,
+			CreatedAt:    m.Timestamp.Unix(),
+		})
+	}
+
+	return rows
+}
+
+// processAttachments downloads, caches and extracts freshly indexed attachments
+func processAttachments(ctx context.Context, rows []database.IndexedAttachments) {
+	for i := range rows {
+		//Rows that already existed weren't inserted and have no ID
+		if rows[i].ID == 0 {
+			continue
+		}
+		if err := attachments.Process(ctx, &rows[i]); err != nil {
+			log.Errorf("Failed to process attachment %s of message %s: %v", rows[i].Filename, rows[i].MessageID, err)
+		}
+	}
+}
+
+// indexMessage stores a message, doing nothing if it is already indexed
+func indexMessage(s *discordgo.Session, m *discordgo.Message) (*database.IndexedMessages, error) {
+	indexedMessage, err := newIndexedMessage(s, m)
+	if err != nil {
+		return nil, err
+	}
+
+	if isOptedOut(m.Author.ID) || !channelAllowed(s, indexedMessage.GuildID, m.ChannelID, scopeIndex) {
+		return indexedMessage, nil
+	}
+
+	if _, err := database.IndexMessages([]*database.IndexedMessages{indexedMessage}); err != nil {
+		log.Errorf("Failed to index message %s in channel %s: %v", m.ID, indexedMessage.ChannelName, err)
+		return nil, err
+	}
+
+	log.Infof("Indexed message %s in channel %s", m.ID, indexedMessage.ChannelName)
+	go processAttachments(context.Background(), indexedMessage.Attachments)
+	return indexedMessage, nil
+}
+
+// referenceID returns the ID of the message m replies to, or an empty string
+func referenceID(m *discordgo.Message) string {
+	if m.MessageReference == nil || m.MessageReference.Type != discordgo.MessageReferenceTypeDefault {
+		return ""
+	}
+	return m.MessageReference.MessageID
+}
+
+func isOptedOut(userID string) bool {
+	user, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return false
+	}
+	return database.IsOptedOut(user)
+}
+
+// stateChannel looks a channel up in the state, falling back to the API. DM
+// channels usually aren't cached until someone asks for them.
+func stateChannel(s *discordgo.Session, channelID string) (*discordgo.Channel, error) {
+	if channel, err := s.State.Channel(channelID); err == nil {
+		return channel, nil
+	}
+
+	channel, err := s.Channel(channelID)
+	if err != nil {
+		return nil, err
+	}
+
+	//The state refuses channels of guilds it doesn't know
+	if channel.GuildID != "" {
+		if _, err := stateGuild(s, channel.GuildID); err != nil {
+			return channel, nil
+		}
+	}
+
+	if err := s.State.ChannelAdd(channel); err != nil {
+		log.Debugf("Failed to cache channel %s: %v", channelID, err)
+	}
+	return channel, nil
+}
+
+// stateGuild looks a guild up in the state, falling back to the API. Sessions
+// without a gateway connection, like the backfill one, start with an empty state.
+func stateGuild(s *discordgo.Session, guildID string) (*discordgo.Guild, error) {
+	if guild, err := s.State.Guild(guildID); err == nil {
+		return guild, nil
+	}
+
+	guild, err := s.Guild(guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.State.GuildAdd(guild); err != nil {
+		log.Debugf("Failed to cache guild %s: %v", guildID, err)
+	}
+	return guild, nil
+}
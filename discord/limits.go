@@ -0,0 +1,205 @@
+package discord
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/DHCPCD9/go-swaga-bot/configuration"
+	"github.com/DHCPCD9/go-swaga-bot/database"
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	errCooldown   = errors.New("cooldown")
+	errUserQuota  = errors.New("daily user quota exhausted")
+	errGuildQuota = errors.New("daily guild quota exhausted")
+	errCrowded    = errors.New("channel or guild cooldown")
+)
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// limiter holds token buckets keyed like "user:<id>", each answer takes one
+// token from every bucket it touches
+type limiter struct {
+	sync.Mutex
+	buckets map[string]*bucket
+	// notified remembers when a user was last told to wait, so spam gets one reply
+	notified map[string]time.Time
+}
+
+var limits = &limiter{buckets: map[string]*bucket{}, notified: map[string]time.Time{}}
+
+type bucketKey struct {
+	key    string
+	config configuration.Bucket
+}
+
+// take removes a token from every bucket if all of them have one. Otherwise
+// nothing is taken and the longest wait until they do is returned.
+func (l *limiter) take(keys []bucketKey, now time.Time) (bool, time.Duration, string) {
+	l.Lock()
+	defer l.Unlock()
+
+	var wait time.Duration
+	var blocking string
+	for _, key := range keys {
+		if key.config.Rate <= 0 || key.config.Per <= 0 {
+			continue
+		}
+
+		b := l.refill(key, now)
+		if b.tokens < 1 {
+			perToken := key.config.Per / time.Duration(key.config.Rate)
+			missing := time.Duration(math.Ceil((1 - b.tokens) * float64(perToken)))
+			if missing > wait {
+				wait, blocking = missing, key.key
+			}
+		}
+	}
+	if wait > 0 {
+		return false, wait, blocking
+	}
+
+	for _, key := range keys {
+		if b, ok := l.buckets[key.key]; ok {
+			b.tokens--
+		}
+	}
+
+	//Full buckets hold no information, dropping them keeps the map small
+	if len(l.buckets) > 10000 {
+		l.prune(keys, now)
+	}
+
+	return true, 0, ""
+}
+
+func (l *limiter) refill(key bucketKey, now time.Time) *bucket {
+	capacity := float64(key.config.Rate)
+	b, ok := l.buckets[key.key]
+	if !ok {
+		b = &bucket{tokens: capacity, updated: now}
+		l.buckets[key.key] = b
+		return b
+	}
+
+	elapsed := now.Sub(b.updated)
+	b.tokens = math.Min(capacity, b.tokens+capacity*elapsed.Seconds()/key.config.Per.Seconds())
+	b.updated = now
+	return b
+}
+
+func (l *limiter) prune(keys []bucketKey, now time.Time) {
+	longest := time.Duration(0)
+	for _, key := range keys {
+		longest = max(longest, key.config.Per)
+	}
+	for key, b := range l.buckets {
+		if now.Sub(b.updated) > longest {
+			delete(l.buckets, key)
+		}
+	}
+	for key, at := range l.notified {
+		if now.Sub(at) > longest {
+			delete(l.notified, key)
+		}
+	}
+}
+
+// shouldNotify reports whether a limited user hasn't been told about it within wait
+func (l *limiter) shouldNotify(userID string, wait time.Duration, now time.Time) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	if at, ok := l.notified[userID]; ok && now.Before(at) {
+		return false
+	}
+	l.notified[userID] = now.Add(wait)
+	return true
+}
+
+// checkLimits decides whether the bot may answer a message: owners and exempt
+// roles pass, everyone else goes through the rate limits and daily quotas
+func checkLimits(m *discordgo.MessageCreate, guildID string) (time.Duration, error) {
+	config := configuration.Config.Limits
+	quotas := config.GuildQuotas(guildID)
+
+	if isOwner(m.Author.ID) {
+		return 0, nil
+	}
+	if m.Member != nil {
+		for _, role := range m.Member.Roles {
+			if slices.Contains(quotas.ExemptRoles, role) {
+				return 0, nil
+			}
+		}
+	}
+
+	now := time.Now()
+	midnight := now.UTC().Truncate(24 * time.Hour)
+	untilTomorrow := midnight.Add(24 * time.Hour).Sub(now)
+
+	if quota := quotas.DailyGuildTokens; quota != nil && *quota > 0 {
+		used, err := database.TokensUsed(guildID, "", midnight.Unix())
+		if err != nil {
+			log.Errorf("Failed to get token usage of guild %s: %v", guildID, err)
+		} else if used >= *quota {
+			return untilTomorrow, errGuildQuota
+		}
+	}
+	if quota := quotas.DailyUserTokens; quota != nil && *quota > 0 {
+		used, err := database.TokensUsed(guildID, m.Author.ID, midnight.Unix())
+		if err != nil {
+			log.Errorf("Failed to get token usage of %s: %v", m.Author.ID, err)
+		} else if used >= *quota {
+			return untilTomorrow, errUserQuota
+		}
+	}
+
+	allowed, wait, blocking := limits.take([]bucketKey{
+		{key: "user:" + m.Author.ID, config: config.User},
+		{key: "channel:" + m.ChannelID, config: config.Channel},
+		{key: "guild:" + guildID, config: config.Guild},
+	}, now)
+	if allowed {
+		return 0, nil
+	}
+	if blocking == "user:"+m.Author.ID {
+		return wait, errCooldown
+	}
+	return wait, errCrowded
+}
+
+// limitReply tells in character why the bot keeps quiet
+func limitReply(err error, wait time.Duration) string {
+	switch {
+	case errors.Is(err, errUserQuota):
+		return "Всё, на сегодня ты меня заболтал, приходи завтра, бака"
+	case errors.Is(err, errGuildQuota):
+		return "Вы тут меня сегодня уже до смерти заговорили, до завтра я молчу"
+	case errors.Is(err, errCrowded):
+		return fmt.Sprintf("Вас тут слишком много, я не успеваю. Дайте мне %s передохнуть", formatWait(wait))
+	default:
+		return fmt.Sprintf("Эй, не так быстро! Подожди %s, потом поговорим", formatWait(wait))
+	}
+}
+
+// formatWait renders a wait like "40 сек." or "3 мин."
+func formatWait(wait time.Duration) string {
+	switch {
+	case wait < time.Minute:
+		return fmt.Sprintf("%d сек.", int(math.Ceil(wait.Seconds())))
+	case wait < time.Hour:
+		return fmt.Sprintf("%d мин.", int(math.Ceil(wait.Minutes())))
+	default:
+		return fmt.Sprintf("%d ч.", int(math.Ceil(wait.Hours())))
+	}
+}
@@ -0,0 +1,255 @@
+package discord
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/DHCPCD9/go-swaga-bot/database"
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+func init() {
+	registerCommand(&command{
+		definition: &discordgo.ApplicationCommand{
+			Name:        "facts",
+			Description: "Что Свага о тебе запомнила",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "list",
+					Description: "Показать запомненные факты",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "forget",
+					Description: "Забыть факт",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:         discordgo.ApplicationCommandOptionString,
+							Name:         "fact",
+							Description:  "Какой факт забыть",
+							Required:     true,
+							Autocomplete: true,
+						},
+					},
+				},
+			},
+		},
+		handler:      handleFactsCommand,
+		autocomplete: autocompleteFacts,
+	})
+
+	registerCommand(&command{
+		definition: &discordgo.ApplicationCommand{
+			Name:        "nicknames",
+			Description: "Как Свага тебя называет",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "list",
+					Description: "Показать запомненные клички",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "remove",
+					Description: "Убрать кличку",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:         discordgo.ApplicationCommandOptionString,
+							Name:         "nickname",
+							Description:  "Какую кличку убрать",
+							Required:     true,
+							Autocomplete: true,
+						},
+					},
+				},
+			},
+		},
+		handler:      handleNicknamesCommand,
+		autocomplete: autocompleteNicknames,
+	})
+}
+
+// userFacts returns what is known about a user for the prompt, newest first
+func userFacts(userID string) []database.UserFact {
+	user, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return nil
+	}
+
+	facts, err := database.ListFacts(user)
+	if err != nil {
+		log.Errorf("Failed to list facts of %d: %v", user, err)
+	}
+	return facts
+}
+
+func handleFactsCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	user, err := strconv.ParseUint(interactionUser(i).ID, 10, 64)
+	if err != nil {
+		log.Errorf("Failed to parse user ID %s: %v", interactionUser(i).ID, err)
+		return
+	}
+
+	option := subcommand(i)
+	if option == nil {
+		return
+	}
+
+	switch option.Name {
+	case "list":
+		facts, err := database.ListFacts(user)
+		if err != nil {
+			log.Errorf("Failed to list facts of %d: %v", user, err)
+			respondEphemeral(s, i, "Не могу сейчас вспомнить, попробуй позже")
+			return
+		}
+		if len(facts) == 0 {
+			respondEphemeral(s, i, "Я про тебя ничего не знаю, ты какой-то загадочный")
+			return
+		}
+
+		lines := make([]string, 0, len(facts))
+		for _, fact := range facts {
+			lines = append(lines, fmt.Sprintf("• %s (<t:%d:d>)", fact.Fact, fact.CreatedAt))
+		}
+		respondEphemeral(s, i, truncate("Вот что я про тебя знаю:\n"+strings.Join(lines, "\n"), 2000))
+	case "forget":
+		id, err := strconv.ParseUint(option.GetOption("fact").StringValue(), 10, 64)
+		if err != nil {
+			respondEphemeral(s, i, "Выбери факт из списка, а не пиши что попало")
+			return
+		}
+
+		removed, err := database.RemoveFactByID(user, id)
+		if err != nil {
+			log.Errorf("Failed to remove fact %d of %d: %v", id, user, err)
+			respondEphemeral(s, i, "Не получилось забыть, оно въелось в память")
+			return
+		}
+		if !removed {
+			respondEphemeral(s, i, "Такого факта у меня нет")
+			return
+		}
+
+		log.Infof("User %d removed fact %d", user, id)
+		respondEphemeral(s, i, "Ладно, забыла. Не то чтобы мне было интересно")
+	}
+}
+
+func autocompleteFacts(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	user, err := strconv.ParseUint(interactionUser(i).ID, 10, 64)
+	if err != nil {
+		return
+	}
+
+	facts, err := database.ListFacts(user)
+	if err != nil {
+		log.Errorf("Failed to list facts of %d: %v", user, err)
+		return
+	}
+
+	query := ""
+	if focused := focusedOption(i.ApplicationCommandData().Options); focused != nil {
+		query = strings.ToLower(focused.StringValue())
+	}
+
+	choices := make([]*discordgo.ApplicationCommandOptionChoice, 0, len(facts))
+	for _, fact := range facts {
+		if query != "" && !strings.Contains(strings.ToLower(fact.Fact), query) {
+			continue
+		}
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+			Name:  truncate(fact.Fact, 100),
+			Value: fmt.Sprint(fact.Id),
+		})
+	}
+
+	respondChoices(s, i, choices)
+}
+
+func handleNicknamesCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	user, err := strconv.ParseUint(interactionUser(i).ID, 10, 64)
+	if err != nil {
+		log.Errorf("Failed to parse user ID %s: %v", interactionUser(i).ID, err)
+		return
+	}
+
+	option := subcommand(i)
+	if option == nil {
+		return
+	}
+
+	switch option.Name {
+	case "list":
+		names, err := database.ListUsernames(user)
+		if err != nil {
+			log.Errorf("Failed to list nicknames of %d: %v", user, err)
+			respondEphemeral(s, i, "Не могу сейчас вспомнить, попробуй позже")
+			return
+		}
+		if len(names) == 0 {
+			respondEphemeral(s, i, "Кличек у тебя пока нет, но я что-нибудь придумаю")
+			return
+		}
+
+		lines := make([]string, 0, len(names))
+		for _, name := range names {
+			lines = append(lines, "• "+name.Name)
+		}
+		respondEphemeral(s, i, truncate("Я тебя знаю как:\n"+strings.Join(lines, "\n"), 2000))
+	case "remove":
+		id, err := strconv.ParseUint(option.GetOption("nickname").StringValue(), 10, 64)
+		if err != nil {
+			respondEphemeral(s, i, "Выбери кличку из списка, а не пиши что попало")
+			return
+		}
+
+		removed, err := database.RemoveUsernameByID(user, id)
+		if err != nil {
+			log.Errorf("Failed to remove nickname %d of %d: %v", id, user, err)
+			respondEphemeral(s, i, "Не получилось, кличка прилипла намертво")
+			return
+		}
+		if !removed {
+			respondEphemeral(s, i, "Я тебя так не называю")
+			return
+		}
+
+		log.Infof("User %d removed nickname %d", user, id)
+		respondEphemeral(s, i, "Ок, больше так называть не буду. Наверное")
+	}
+}
+
+func autocompleteNicknames(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	user, err := strconv.ParseUint(interactionUser(i).ID, 10, 64)
+	if err != nil {
+		return
+	}
+
+	names, err := database.ListUsernames(user)
+	if err != nil {
+		log.Errorf("Failed to list nicknames of %d: %v", user, err)
+		return
+	}
+
+	query := ""
+	if focused := focusedOption(i.ApplicationCommandData().Options); focused != nil {
+		query = strings.ToLower(focused.StringValue())
+	}
+
+	choices := make([]*discordgo.ApplicationCommandOptionChoice, 0, len(names))
+	for _, name := range names {
+		if query != "" && !strings.Contains(strings.ToLower(name.Name), query) {
+			continue
+		}
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+			Name:  truncate(name.Name, 100),
+			Value: fmt.Sprint(name.Id),
+		})
+	}
+
+	respondChoices(s, i, choices)
+}
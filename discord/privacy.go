@@ -0,0 +1,122 @@
+package discord
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/DHCPCD9/go-swaga-bot/attachments"
+	"github.com/DHCPCD9/go-swaga-bot/database"
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+func init() {
+	registerCommand(&command{
+		definition: &discordgo.ApplicationCommand{
+			Name:        "privacy",
+			Description: "Разрешить или запретить Сваге запоминать тебя",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "opt-out",
+					Description: "Не сохранять мои сообщения и факты обо мне",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "opt-in",
+					Description: "Снова разрешить запоминать меня",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "status",
+					Description: "Показать текущую настройку",
+				},
+			},
+		},
+		handler: handlePrivacyCommand,
+	})
+
+	registerCommand(&command{
+		definition: &discordgo.ApplicationCommand{
+			Name:        "forget-me",
+			Description: "Удалить все мои сообщения, факты и клички",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Name:        "opt-out",
+					Description: "Заодно запретить запоминать меня дальше",
+				},
+			},
+		},
+		handler: handleForgetMeCommand,
+	})
+}
+
+func handlePrivacyCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	user, err := strconv.ParseUint(interactionUser(i).ID, 10, 64)
+	if err != nil {
+		log.Errorf("Failed to parse user ID %s: %v", interactionUser(i).ID, err)
+		return
+	}
+
+	option := subcommand(i)
+	if option == nil {
+		return
+	}
+
+	switch option.Name {
+	case "opt-out", "opt-in":
+		optedOut := option.Name == "opt-out"
+		if err := database.SetOptedOut(user, optedOut); err != nil {
+			log.Errorf("Failed to change opt-out of %d: %v", user, err)
+			respondEphemeral(s, i, "Не получилось сохранить, попробуй позже")
+			return
+		}
+
+		log.Infof("User %d set opted out to %t", user, optedOut)
+		if optedOut {
+			respondEphemeral(s, i, "Всё, больше ничего за тобой не записываю. Старое можно удалить через /forget-me")
+		} else {
+			respondEphemeral(s, i, "Ладно, снова тебя запоминаю. Не то чтобы я скучала")
+		}
+	case "status":
+		if database.IsOptedOut(user) {
+			respondEphemeral(s, i, "Я тебя не запоминаю")
+		} else {
+			respondEphemeral(s, i, "Я запоминаю твои сообщения и факты о тебе")
+		}
+	}
+}
+
+func handleForgetMeCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	user, err := strconv.ParseUint(interactionUser(i).ID, 10, 64)
+	if err != nil {
+		log.Errorf("Failed to parse user ID %s: %v", interactionUser(i).ID, err)
+		return
+	}
+
+	optOut := false
+	for _, option := range i.ApplicationCommandData().Options {
+		if option.Name == "opt-out" {
+			optOut = option.BoolValue()
+		}
+	}
+
+	if optOut {
+		if err := database.SetOptedOut(user, true); err != nil {
+			log.Errorf("Failed to opt out %d: %v", user, err)
+			respondEphemeral(s, i, "Не получилось, попробуй позже")
+			return
+		}
+	}
+
+	result, err := database.ForgetUser(user)
+	if err != nil {
+		log.Errorf("Failed to forget user %d: %v", user, err)
+		respondEphemeral(s, i, "Не получилось всё удалить, попробуй позже")
+		return
+	}
+	attachments.Remove(result.Files)
+
+	respondEphemeral(s, i, fmt.Sprintf("Готово, удалила %d сообщений, %d фактов и %d кличек. Кто ты вообще такой?", result.Messages, result.Facts, result.Usernames))
+}
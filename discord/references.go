@@ -0,0 +1,25 @@
+package discord
+
+import (
+	"github.com/DHCPCD9/go-swaga-bot/configuration"
+	"github.com/DHCPCD9/go-swaga-bot/conversation"
+	"github.com/DHCPCD9/go-swaga-bot/database"
+	"github.com/bwmarrin/discordgo"
+)
+
+// replyChain resolves the messages m replies to, oldest first. Messages the bot
+// hasn't seen are fetched from Discord and indexed on the way.
+func replyChain(s *discordgo.Session, m *discordgo.Message) []database.IndexedMessages {
+	channelID := m.ChannelID
+	if m.MessageReference != nil && m.MessageReference.ChannelID != "" {
+		channelID = m.MessageReference.ChannelID
+	}
+
+	return conversation.ReplyChain(referenceID(m), configuration.Config.Context.ThreadDepth, func(messageID string) (*database.IndexedMessages, error) {
+		message, err := s.ChannelMessage(channelID, messageID)
+		if err != nil {
+			return nil, err
+		}
+		return indexMessage(s, message)
+	})
+}
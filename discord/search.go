@@ -0,0 +1,93 @@
+package discord
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/DHCPCD9/go-swaga-bot/database"
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+const searchResults = 10
+
+func init() {
+	registerCommand(&command{
+		definition: &discordgo.ApplicationCommand{
+			Name:        "search",
+			Description: "Найти старые сообщения",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "query",
+					Description: "Слова из сообщения",
+					Required:    true,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionUser,
+					Name:        "user",
+					Description: "Автор",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionChannel,
+					Name:        "channel",
+					Description: "Канал",
+				},
+			},
+		},
+		handler: handleSearchCommand,
+	})
+}
+
+func handleSearchCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	query := database.MessageQuery{GuildID: i.GuildID, Limit: searchResults}
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case "query":
+			query.Text = option.StringValue()
+		case "user":
+			query.AuthorID = option.UserValue(nil).ID
+		case "channel":
+			query.ChannelID = option.Value.(string)
+		}
+	}
+
+	userID := interactionUser(i).ID
+	if i.GuildID == "" {
+		query.ChannelID = i.ChannelID
+	} else {
+		query.Visible = func(channelID string) bool {
+			return canRead(s, userID, channelID)
+		}
+	}
+
+	messages, err := database.SearchMessages(query)
+	if err != nil {
+		log.Errorf("Failed to search messages for %q: %v", query.Text, err)
+		respondEphemeral(s, i, "Поиск сломался, попробуй позже")
+		return
+	}
+	if len(messages) == 0 {
+		respondEphemeral(s, i, "Ничего не нашла, может ты это выдумал?")
+		return
+	}
+
+	guild := i.GuildID
+	if guild == "" {
+		guild = "@me"
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "Вот что нашла по «%s»:\n", truncate(query.Text, 100))
+	for _, message := range messages {
+		snippet := truncate(strings.Join(strings.Fields(message.Content), " "), 120)
+		line := fmt.Sprintf("- <t:%d:d> **%s**: %s [→](https://discord.com/channels/%s/%s/%s)\n", message.CreatedAt, message.Username, snippet, guild, message.ChannelID, message.MessageID)
+		//Whole lines only, a cut link is useless
+		if text.Len()+len(line) > 2000 {
+			break
+		}
+		text.WriteString(line)
+	}
+
+	respondEphemeral(s, i, text.String())
+}
@@ -0,0 +1,114 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/DHCPCD9/go-swaga-bot/configuration"
+	"github.com/DHCPCD9/go-swaga-bot/conversation"
+	"github.com/DHCPCD9/go-swaga-bot/llm"
+	"github.com/DHCPCD9/go-swaga-bot/tools"
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+// generateWithTools lets the model call tools until it stops, then asks for the
+// answer with the response schema, which providers may ignore next to tools.
+// Every round trip is one more request, so after max-tool-steps it has to answer.
+func generateWithTools(ctx context.Context, s *discordgo.Session, scope usageScope, messageID string, request *llm.Request) (*llm.Response, error) {
+	request.Tools = tools.Definitions()
+	invocation := tools.Invocation{
+		GuildID:   scope.GuildID,
+		ChannelID: scope.ChannelID,
+		UserID:    scope.UserID,
+		MessageID: messageID,
+		CanRead: func(channelID string) bool {
+			return canRead(s, scope.UserID, channelID)
+		},
+	}
+
+	for step := 0; step < configuration.Config.LLM.MaxToolSteps; step++ {
+		response, err := generate(ctx, scope, request)
+		if err != nil {
+			return nil, err
+		}
+		if len(response.FunctionCalls) == 0 {
+			//Without a schema the answer is already the final one
+			if request.ResponseSchema == nil {
+				return response, nil
+			}
+			break
+		}
+
+		invocation.Model = response.Model
+		results := make([]llm.Part, 0, len(response.FunctionCalls))
+		for _, call := range response.FunctionCalls {
+			results = append(results, llm.Part{FunctionResponse: tools.Call(ctx, invocation, call)})
+		}
+
+		request.Messages = append(request.Messages,
+			llm.Message{Role: llm.RoleModel, Parts: response.Parts},
+			llm.UserMessage(results...),
+		)
+	}
+
+	return generate(ctx, scope, &llm.Request{
+		System:         request.System,
+		Messages:       flattenToolCalls(request.Messages),
+		ResponseSchema: request.ResponseSchema,
+	})
+}
+
+// flattenToolCalls turns tool calls and their results into text, a request
+// without tools can't carry them as they are
+func flattenToolCalls(messages []llm.Message) []llm.Message {
+	flattened := make([]llm.Message, 0, len(messages))
+	for _, message := range messages {
+		parts := make([]llm.Part, 0, len(message.Parts))
+		for _, part := range message.Parts {
+			switch {
+			case part.FunctionCall != nil:
+				parts = append(parts, llm.TextPart(fmt.Sprintf("Вызов %s: %s", part.FunctionCall.Name, part.FunctionCall.Args)))
+			case part.FunctionResponse != nil:
+				result, _ := json.Marshal(part.FunctionResponse.Response)
+				parts = append(parts, llm.TextPart(fmt.Sprintf("Результат %s: %s", part.FunctionResponse.Name, result)))
+			default:
+				parts = append(parts, part)
+			}
+		}
+		flattened = append(flattened, llm.Message{Role: message.Role, Parts: parts})
+	}
+	return conversation.MergeTurns(flattened)
+}
+
+// canRead reports whether a user may view a channel, so tools don't show them
+// messages from channels hidden from them. Threads follow their parent channel.
+func canRead(s *discordgo.Session, userID string, channelID string) bool {
+	channel, err := stateChannel(s, channelID)
+	if err != nil {
+		log.Debugf("Failed to get channel %s: %v", channelID, err)
+		return false
+	}
+	//Direct message searches never leave the current conversation
+	if channel.GuildID == "" {
+		return true
+	}
+	if channel.IsThread() {
+		if channel, err = stateChannel(s, channel.ParentID); err != nil {
+			return false
+		}
+	}
+
+	if _, err := s.State.Member(channel.GuildID, userID); err != nil {
+		member, err := s.GuildMember(channel.GuildID, userID)
+		if err != nil {
+			log.Debugf("Failed to get member %s of guild %s: %v", userID, channel.GuildID, err)
+			return false
+		}
+		s.State.MemberAdd(member)
+	}
+
+	permissions, err := s.State.UserChannelPermissions(userID, channel.ID)
+	return err == nil && permissions&discordgo.PermissionViewChannel != 0
+}
@@ -0,0 +1,168 @@
+package discord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/DHCPCD9/go-swaga-bot/configuration"
+	"github.com/DHCPCD9/go-swaga-bot/database"
+	"github.com/DHCPCD9/go-swaga-bot/llm"
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+// usageScope says who a model request was made for
+type usageScope struct {
+	GuildID   string
+	ChannelID string
+	UserID    string
+}
+
+func init() {
+	permissions := int64(discordgo.PermissionManageGuild)
+	minDays := float64(1)
+
+	registerCommand(&command{
+		definition: &discordgo.ApplicationCommand{
+			Name:                     "usage",
+			Description:              "Сколько Свага наговорила и во сколько это обошлось",
+			DefaultMemberPermissions: &permissions,
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        "days",
+					Description: "За сколько последних дней, по умолчанию 7",
+					MinValue:    &minDays,
+					MaxValue:    90,
+				},
+			},
+		},
+		handler: handleUsageCommand,
+	})
+}
+
+// generate asks the model and records what the answer cost. Failed calls are
+// recorded too, blocked answers still cost tokens.
+func generate(ctx context.Context, scope usageScope, request *llm.Request) (*llm.Response, error) {
+	started := time.Now()
+	response, err := llm.Default.Generate(ctx, request)
+
+	model, usage, finishReason := llm.Default.Model(), llm.Usage{}, ""
+	var apiError *llm.APIError
+	switch {
+	case err == nil:
+		model, usage, finishReason = response.Model, response.Usage, response.FinishReason
+	case errors.As(err, &apiError):
+		usage, finishReason = apiError.Usage, "error: "+apiError.Kind.Error()
+		if apiError.Model != "" {
+			model = apiError.Model
+		}
+	default:
+		finishReason = "error: " + err.Error()
+	}
+
+	record := &database.LLMUsage{
+		GuildID:          scope.GuildID,
+		ChannelID:        scope.ChannelID,
+		UserID:           scope.UserID,
+		Provider:         llm.Default.Name(),
+		Model:            model,
+		PromptTokens:     usage.PromptTokens,
+		CandidatesTokens: usage.CandidatesTokens,
+		TotalTokens:      usage.TotalTokens,
+		LatencyMs:        time.Since(started).Milliseconds(),
+		FinishReason:     finishReason,
+		Cost:             llm.Cost(configuration.Config.LLM.Prices, model, usage),
+	}
+	if err := database.RecordUsage(record); err != nil {
+		log.Errorf("Failed to record usage of %s: %v", model, err)
+	}
+
+	return response, err
+}
+
+func isOwner(userID string) bool {
+	return slices.Contains(configuration.Config.Discord.Owners, userID)
+}
+
+// handleUsageCommand shows spend per day and the top users of the guild, owners
+// also get every guild. In DMs only owners get an answer.
+func handleUsageCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	days := int64(7)
+	for _, option := range i.ApplicationCommandData().Options {
+		if option.Name == "days" {
+			days = option.IntValue()
+		}
+	}
+
+	owner := isOwner(interactionUser(i).ID)
+	if i.GuildID == "" && !owner {
+		respondEphemeral(s, i, "А тебе зачем? Это не твоё дело")
+		return
+	}
+
+	since := time.Now().Add(-time.Duration(days) * 24 * time.Hour).Unix()
+
+	byDay, err := database.UsageByDay(i.GuildID, since)
+	if err != nil {
+		log.Errorf("Failed to get usage by day: %v", err)
+		respondEphemeral(s, i, "Не могу сейчас посчитать, попробуй позже")
+		return
+	}
+	if len(byDay) == 0 {
+		respondEphemeral(s, i, fmt.Sprintf("За %d дн. я тут ни слова не сказала", days))
+		return
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "Расходы за %d дн.:\n```\n%-10s %8s %12s %10s\n", days, "День", "Запросы", "Токены", "USD")
+	sum := database.UsageTotal{Key: "Итого"}
+	for n, total := range byDay {
+		sum.Requests += total.Requests
+		sum.TotalTokens += total.TotalTokens
+		sum.Cost += total.Cost
+		//Only the last two weeks fit into a message, older days go into the total only
+		if n < 14 {
+			fmt.Fprintf(&text, "%-10s %8d %12d %10.4f\n", total.Key, total.Requests, total.TotalTokens, total.Cost)
+		}
+	}
+	fmt.Fprintf(&text, "%-10s %8d %12d %10.4f\n```\n", sum.Key, sum.Requests, sum.TotalTokens, sum.Cost)
+
+	if owner {
+		byGuild, err := database.UsageByGuild(since)
+		if err != nil {
+			log.Errorf("Failed to get usage by guild: %v", err)
+		} else {
+			text.WriteString("По серверам:\n")
+			for _, total := range byGuild {
+				fmt.Fprintf(&text, "- %s: %d запросов, %d токенов, $%.4f\n", usageGuildName(s, total.Key), total.Requests, total.TotalTokens, total.Cost)
+			}
+		}
+	}
+
+	topUsers, err := database.TopUsers(i.GuildID, since, 10)
+	if err != nil {
+		log.Errorf("Failed to get top users: %v", err)
+	} else {
+		text.WriteString("Больше всех болтали со мной:\n")
+		for n, total := range topUsers {
+			fmt.Fprintf(&text, "%d. <@%s>: %d запросов, %d токенов, $%.4f\n", n+1, total.Key, total.Requests, total.TotalTokens, total.Cost)
+		}
+	}
+
+	respondEphemeral(s, i, truncate(text.String(), 2000))
+}
+
+func usageGuildName(s *discordgo.Session, guildID string) string {
+	if guildID == "" {
+		return "личные сообщения"
+	}
+	if guild, err := s.State.Guild(guildID); err == nil && guild.Name != "" {
+		return guild.Name
+	}
+	return guildID
+}
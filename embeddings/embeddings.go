@@ -0,0 +1,136 @@
+package embeddings
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/DHCPCD9/go-swaga-bot/configuration"
+	"github.com/DHCPCD9/go-swaga-bot/database"
+	"github.com/DHCPCD9/go-swaga-bot/llm"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// MaxTextLength in runes, embedding models cut longer texts anyway
+	MaxTextLength = 2000
+	// MaxBatches per run, so a fresh backfill is caught up with over a few runs
+	MaxBatches = 10
+	// PruneEvery runs vectors of deleted messages and facts are dropped
+	PruneEvery = 20
+)
+
+// Start embeds new messages and facts in the background until ctx is done
+func Start(ctx context.Context) {
+	config := configuration.Config.Embeddings
+	if !config.Enabled {
+		return
+	}
+
+	log.Infof("Embedding messages with %s every %s", llm.EmbeddingModel(llm.Default), config.Interval)
+	go func() {
+		ticker := time.NewTicker(config.Interval)
+		defer ticker.Stop()
+
+		for run := 0; ; run++ {
+			if err := Run(ctx); err != nil {
+				if errors.Is(err, llm.ErrNotSupported) {
+					log.Errorf("%s provider can't embed, semantic memory is off", llm.Default.Name())
+					return
+				}
+				log.Errorf("Failed to embed messages: %v", err)
+			}
+
+			if run%PruneEvery == 0 {
+				if pruned, err := database.PruneEmbeddings(); err != nil {
+					log.Errorf("Failed to prune embeddings: %v", err)
+				} else if pruned > 0 {
+					log.Debugf("Pruned %d embeddings", pruned)
+				}
+				//Edits replace vectors without pruning, so the index reloads either way
+				index.Reset()
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Run embeds what has no vector yet, newest first
+func Run(ctx context.Context) error {
+	config := configuration.Config.Embeddings
+	model := llm.EmbeddingModel(llm.Default)
+
+	for batch := 0; batch < MaxBatches; batch++ {
+		messages, err := database.PendingMessages(model, config.MinLength, config.BatchSize)
+		if err != nil {
+			return err
+		}
+		var facts []database.UserFact
+		if len(messages) < config.BatchSize {
+			if facts, err = database.PendingFacts(model, config.BatchSize-len(messages)); err != nil {
+				return err
+			}
+		}
+		if len(messages) == 0 && len(facts) == 0 {
+			return nil
+		}
+
+		pending := make([]database.Embedding, 0, len(messages)+len(facts))
+		texts := make([]string, 0, len(messages)+len(facts))
+		for _, message := range messages {
+			pending = append(pending, database.Embedding{
+				Kind:      database.EmbeddingMessage,
+				SourceID:  message.MessageID,
+				GuildID:   message.GuildID,
+				ChannelID: message.ChannelID,
+				AuthorID:  message.AuthorID,
+			})
+			texts = append(texts, truncate(message.Username+": "+message.Content))
+		}
+		for _, fact := range facts {
+			pending = append(pending, database.Embedding{
+				Kind:     database.EmbeddingFact,
+				SourceID: strconv.FormatUint(fact.Id, 10),
+				AuthorID: strconv.FormatUint(fact.UserID, 10),
+			})
+			texts = append(texts, truncate(fact.Fact))
+		}
+
+		vectors, err := llm.Default.Embed(ctx, texts)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().Unix()
+		for i := range pending {
+			pending[i].Model = model
+			pending[i].Dimensions = len(vectors[i])
+			pending[i].Vector = database.EncodeVector(vectors[i])
+			pending[i].CreatedAt = now
+		}
+		if err := database.SaveEmbeddings(pending); err != nil {
+			return err
+		}
+
+		log.Debugf("Embedded %d messages and %d facts", len(messages), len(facts))
+		if len(pending) < config.BatchSize {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func truncate(text string) string {
+	if utf8.RuneCountInString(text) <= MaxTextLength {
+		return text
+	}
+	return string([]rune(text)[:MaxTextLength])
+}
@@ -0,0 +1,104 @@
+package embeddings
+
+import (
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/DHCPCD9/go-swaga-bot/database"
+)
+
+// Index keeps vectors in memory for databases without a vector index. Lists
+// are loaded on first use and then only pick up rows added since.
+type Index struct {
+	mu    sync.Mutex
+	lists map[string]*list
+}
+
+type list struct {
+	last    uint
+	entries []entry
+}
+
+type entry struct {
+	embedding database.Embedding // without Vector
+	vector    []float32          // normalized, so similarity is a dot product
+}
+
+var index = &Index{lists: map[string]*list{}}
+
+// Reset drops everything, the next search loads it again
+func (i *Index) Reset() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.lists = map[string]*list{}
+}
+
+// Nearest returns up to limit vectors of kind most similar to vector, skipping those keep rejects
+func (i *Index) Nearest(kind string, model string, guildID string, vector []float32, minSimilarity float64, limit int, keep func(database.Embedding) bool) ([]database.ScoredEmbedding, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	key := kind + "/" + model
+	if kind == database.EmbeddingMessage {
+		key += "/" + guildID
+	}
+	found, ok := i.lists[key]
+	if !ok {
+		found = &list{}
+		i.lists[key] = found
+	}
+
+	loaded, err := database.LoadEmbeddings(kind, model, guildID, found.last)
+	if err != nil {
+		return nil, err
+	}
+	for _, embedding := range loaded {
+		found.last = embedding.ID
+		normalized := normalize(database.DecodeVector(embedding.Vector))
+		embedding.Vector = nil
+		found.entries = append(found.entries, entry{embedding: embedding, vector: normalized})
+	}
+
+	query := normalize(vector)
+	var scored []database.ScoredEmbedding
+	for _, entry := range found.entries {
+		if len(entry.vector) != len(query) || !keep(entry.embedding) {
+			continue
+		}
+
+		var similarity float64
+		for j, value := range entry.vector {
+			similarity += float64(value) * float64(query[j])
+		}
+		if similarity >= minSimilarity {
+			scored = append(scored, database.ScoredEmbedding{Embedding: entry.embedding, Similarity: similarity})
+		}
+	}
+
+	sort.Slice(scored, func(a, b int) bool {
+		return scored[a].Similarity > scored[b].Similarity
+	})
+	if len(scored) > limit {
+		scored = scored[:limit]
+	}
+
+	return scored, nil
+}
+
+func normalize(vector []float32) []float32 {
+	var norm float64
+	for _, value := range vector {
+		norm += float64(value) * float64(value)
+	}
+	if norm == 0 {
+		return vector
+	}
+
+	norm = math.Sqrt(norm)
+	normalized := make([]float32, len(vector))
+	for i, value := range vector {
+		normalized[i] = float32(float64(value) / norm)
+	}
+	return normalized
+}
@@ -0,0 +1,143 @@
+package embeddings
+
+import (
+	"context"
+	"sort"
+	"strconv"
+
+	"github.com/DHCPCD9/go-swaga-bot/configuration"
+	"github.com/DHCPCD9/go-swaga-bot/database"
+	"github.com/DHCPCD9/go-swaga-bot/llm"
+)
+
+// Query describes what the related messages and facts are looked up for
+type Query struct {
+	GuildID   string
+	ChannelID string
+	Text      string
+	Exclude   map[string]bool             // message IDs already in the context
+	Visible   func(channelID string) bool // nil allows every channel of the guild
+}
+
+// Related holds past messages and facts similar to the question, messages oldest first
+type Related struct {
+	Messages []database.IndexedMessages
+	Facts    []database.UserFact
+}
+
+// Find looks up messages and facts similar to query.Text. Messages come from
+// the same guild, or the same DM channel, facts only about people who wrote
+// something there.
+func Find(ctx context.Context, query Query) (*Related, error) {
+	config := configuration.Config.Embeddings
+	related := &Related{}
+	if !config.Enabled || query.Text == "" {
+		return related, nil
+	}
+
+	vectors, err := llm.Default.Embed(ctx, []string{truncate(query.Text)})
+	if err != nil {
+		return nil, err
+	}
+	vector := vectors[0]
+	model := llm.EmbeddingModel(llm.Default)
+
+	keepMessage := func(embedding database.Embedding) bool {
+		if query.Exclude[embedding.SourceID] {
+			return false
+		}
+		//Every DM shares the empty guild
+		if query.GuildID == "" && embedding.ChannelID != query.ChannelID {
+			return false
+		}
+		return query.Visible == nil || query.Visible(embedding.ChannelID)
+	}
+
+	if config.RelatedMessages > 0 {
+		scored, err := nearest(database.EmbeddingMessage, model, query.GuildID, vector, config.RelatedMessages, keepMessage)
+		if err != nil {
+			return nil, err
+		}
+
+		ids := make([]string, 0, len(scored))
+		for _, hit := range scored {
+			ids = append(ids, hit.SourceID)
+		}
+		if related.Messages, err = database.MessagesByID(ids); err != nil {
+			return nil, err
+		}
+		sort.Slice(related.Messages, func(i, j int) bool {
+			return related.Messages[i].CreatedAt < related.Messages[j].CreatedAt
+		})
+	}
+
+	if config.RelatedFacts > 0 && query.GuildID != "" {
+		//Facts of people from other servers stay there
+		scored, err := nearest(database.EmbeddingFact, model, "", vector, config.RelatedFacts*4, func(database.Embedding) bool {
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		authors := make([]string, 0, len(scored))
+		for _, hit := range scored {
+			authors = append(authors, hit.AuthorID)
+		}
+		members, err := database.GuildAuthors(query.GuildID, authors)
+		if err != nil {
+			return nil, err
+		}
+
+		ids := make([]uint64, 0, config.RelatedFacts)
+		for _, hit := range scored {
+			if !members[hit.AuthorID] || len(ids) == config.RelatedFacts {
+				continue
+			}
+			if id, err := strconv.ParseUint(hit.SourceID, 10, 64); err == nil {
+				ids = append(ids, id)
+			}
+		}
+		if related.Facts, err = database.FactsByID(ids); err != nil {
+			return nil, err
+		}
+	}
+
+	return related, nil
+}
+
+// nearest searches pgvector when there is one and the in-memory index otherwise.
+// A message edited since the index loaded it has two vectors, only the best hit is kept.
+func nearest(kind string, model string, guildID string, vector []float32, limit int, keep func(database.Embedding) bool) ([]database.ScoredEmbedding, error) {
+	minSimilarity := configuration.Config.Embeddings.MinSimilarity
+
+	var scored []database.ScoredEmbedding
+	if database.VectorIndex {
+		//Filters run afterwards, so ask for more than needed
+		candidates, err := database.NearestEmbeddings(kind, model, guildID, vector, limit*4)
+		if err != nil {
+			return nil, err
+		}
+		for _, hit := range candidates {
+			if hit.Similarity >= minSimilarity && keep(hit.Embedding) {
+				scored = append(scored, hit)
+			}
+		}
+	} else {
+		var err error
+		if scored, err = index.Nearest(kind, model, guildID, vector, minSimilarity, limit*2, keep); err != nil {
+			return nil, err
+		}
+	}
+
+	seen := map[string]bool{}
+	var unique []database.ScoredEmbedding
+	for _, hit := range scored {
+		if seen[hit.SourceID] || len(unique) == limit {
+			continue
+		}
+		seen[hit.SourceID] = true
+		unique = append(unique, hit)
+	}
+	return unique, nil
+}
@@ -0,0 +1,163 @@
+package gemini
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/DHCPCD9/go-swaga-bot/configuration"
+	"github.com/DHCPCD9/go-swaga-bot/llm"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultBaseURL        = "https://generativelanguage.googleapis.com"
+	DefaultAPIVersion     = "v1beta"
+	DefaultModel          = "gemini-2.5-flash"
+	DefaultEmbeddingModel = "gemini-embedding-001"
+)
+
+// Client talks to the Gemini REST API. BaseURL can point to anything that speaks
+// the same protocol, e.g. a local mock server.
+type Client struct {
+	BaseURL        string
+	APIVersion     string
+	Model          string
+	EmbeddingModel string
+	Dimensions     int // of embeddings, 0 for the model default
+	Token          string
+	HTTPClient     *http.Client
+	Retry          llm.RetryPolicy
+}
+
+var DefaultClient *Client
+
+func NewClient(config configuration.Gemini) *Client {
+	client := &Client{
+		BaseURL:        strings.TrimRight(config.BaseURL, "/"),
+		APIVersion:     strings.Trim(config.APIVersion, "/"),
+		Model:          strings.TrimPrefix(config.Model, "models/"),
+		EmbeddingModel: strings.TrimPrefix(config.EmbeddingModel, "models/"),
+		Token:          config.Token,
+		HTTPClient:     http.DefaultClient,
+		Retry:          llm.DefaultRetryPolicy,
+	}
+
+	if client.BaseURL == "" {
+		client.BaseURL = DefaultBaseURL
+	}
+	if client.APIVersion == "" {
+		client.APIVersion = DefaultAPIVersion
+	}
+	if client.Model == "" {
+		client.Model = DefaultModel
+	}
+	if client.EmbeddingModel == "" {
+		client.EmbeddingModel = DefaultEmbeddingModel
+	}
+
+	return client
+}
+
+func Init() error {
+	if configuration.Config == nil {
+		return fmt.Errorf("configuration not loaded")
+	}
+
+	DefaultClient = NewClient(configuration.Config.Gemini)
+	DefaultClient.Retry = llm.RetryPolicyFromConfig(configuration.Config.LLM.Retry)
+	DefaultClient.Dimensions = configuration.Config.Embeddings.Dimensions
+	log.Infof("Using Gemini model %s at %s/%s", DefaultClient.Model, DefaultClient.BaseURL, DefaultClient.APIVersion)
+	return nil
+}
+
+// ModelURL returns the URL of a model method, e.g. generateContent or countTokens
+func (c *Client) ModelURL(method string) string {
+	return fmt.Sprintf("%s/%s/models/%s:%s", c.BaseURL, c.APIVersion, c.Model, method)
+}
+
+// EmbeddingModelURL is like ModelURL, but for the configured embedding model
+func (c *Client) EmbeddingModelURL(method string) string {
+	return fmt.Sprintf("%s/%s/models/%s:%s", c.BaseURL, c.APIVersion, c.EmbeddingModel, method)
+}
+
+func (c *Client) GenerateContent(ctx context.Context, body *GeminiBody) (*GeminiResponse, error) {
+	var geminiResponse GeminiResponse
+	if err := c.post(ctx, c.ModelURL("generateContent"), body, &geminiResponse); err != nil {
+		return nil, err
+	}
+
+	return &geminiResponse, nil
+}
+
+func (c *Client) CountTokens(ctx context.Context, body any) (*CountTokensResponse, error) {
+	var countResponse CountTokensResponse
+	if err := c.post(ctx, c.ModelURL("countTokens"), body, &countResponse); err != nil {
+		return nil, err
+	}
+
+	return &countResponse, nil
+}
+
+func (c *Client) BatchEmbedContents(ctx context.Context, body *BatchEmbedContentsBody) (*BatchEmbedContentsResponse, error) {
+	var embedResponse BatchEmbedContentsResponse
+	if err := c.post(ctx, c.EmbeddingModelURL("batchEmbedContents"), body, &embedResponse); err != nil {
+		return nil, err
+	}
+
+	return &embedResponse, nil
+}
+
+func (c *Client) post(ctx context.Context, url string, body any, out any) error {
+	bodyData, err := json.Marshal(body)
+	if err != nil {
+		log.Errorf("Failed to marshal request: %v", err)
+		return err
+	}
+
+	return llm.Retry(ctx, c.Retry, func() error {
+		return c.do(ctx, url, bodyData, out)
+	})
+}
+
+func (c *Client) do(ctx context.Context, url string, bodyData []byte, out any) error {
+	request, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(bodyData))
+	if err != nil {
+		log.Errorf("Failed to create request: %v", err)
+		return err
+	}
+	request.Header.Set("x-goog-api-key", c.Token)
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := c.HTTPClient.Do(request)
+	if err != nil {
+		log.Errorf("Failed to send request: %v", err)
+		if ctx.Err() != nil {
+			return err
+		}
+		return &llm.APIError{Kind: llm.ErrServerError, Message: err.Error()}
+	}
+	defer response.Body.Close()
+
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		log.Errorf("Failed to read response body: %v", err)
+		return err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		log.Errorf("Request failed with status code %d: %s", response.StatusCode, responseBody)
+		return parseError(response, responseBody)
+	}
+
+	if err := json.Unmarshal(responseBody, out); err != nil {
+		log.Errorf("Failed to unmarshal response: %v", err)
+		return err
+	}
+
+	return nil
+}
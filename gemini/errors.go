@@ -0,0 +1,70 @@
+package gemini
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/DHCPCD9/go-swaga-bot/llm"
+)
+
+type ErrorResponse struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+		Details []struct {
+			Type       string `json:"@type"`
+			RetryDelay string `json:"retryDelay"`
+			Violations []struct {
+				QuotaID string `json:"quotaId"`
+			} `json:"violations"`
+		} `json:"details"`
+	} `json:"error"`
+}
+
+// Finish reasons meaning the answer was cut by safety filters
+var safetyFinishReasons = []string{"SAFETY", "PROHIBITED_CONTENT", "BLOCKLIST", "SPII", "IMAGE_SAFETY"}
+
+// parseError turns a non 200 response into llm.APIError. Gemini reports both
+// per-minute rate limits and exhausted daily quotas as 429, quota ids tell them apart.
+func parseError(response *http.Response, body []byte) *llm.APIError {
+	apiError := &llm.APIError{
+		Kind:       llm.KindFromStatus(response.StatusCode),
+		StatusCode: response.StatusCode,
+		RetryAfter: llm.ParseRetryAfter(response.Header.Get("Retry-After")),
+		Message:    string(body),
+	}
+
+	var parsed ErrorResponse
+	if err := json.Unmarshal(body, &parsed); err != nil || parsed.Error.Message == "" {
+		return apiError
+	}
+
+	apiError.Message = parsed.Error.Message
+	for _, detail := range parsed.Error.Details {
+		if detail.RetryDelay != "" && apiError.RetryAfter == 0 {
+			if delay, err := time.ParseDuration(detail.RetryDelay); err == nil {
+				apiError.RetryAfter = delay
+			}
+		}
+
+		for _, violation := range detail.Violations {
+			if strings.Contains(violation.QuotaID, "PerDay") {
+				apiError.Kind = llm.ErrQuotaExhausted
+			}
+		}
+	}
+
+	return apiError
+}
+
+func isSafetyFinishReason(reason string) bool {
+	for _, safetyReason := range safetyFinishReasons {
+		if reason == safetyReason {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,279 @@
+package gemini
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/DHCPCD9/go-swaga-bot/llm"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	FileStateProcessing = "PROCESSING"
+	FileStateActive     = "ACTIVE"
+	FileStateFailed     = "FAILED"
+)
+
+// UploadChunkSize is how much is sent per upload request, the API wants multiples of 256 KiB
+var UploadChunkSize = 8 * 1024 * 1024
+
+// FilePollInterval is how often the state of a processing file is checked
+var FilePollInterval = 2 * time.Second
+
+// File is a file stored by the Files API, uploads expire after 48 hours on their own
+type File struct {
+	Name           string `json:"name"`
+	DisplayName    string `json:"displayName,omitempty"`
+	MimeType       string `json:"mimeType,omitempty"`
+	SizeBytes      string `json:"sizeBytes,omitempty"`
+	CreateTime     string `json:"createTime,omitempty"`
+	ExpirationTime string `json:"expirationTime,omitempty"`
+	Sha256Hash     string `json:"sha256Hash,omitempty"`
+	URI            string `json:"uri,omitempty"`
+	State          string `json:"state,omitempty"`
+	Error          *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+type fileResponse struct {
+	File File `json:"file"`
+}
+
+type ListFilesResponse struct {
+	Files         []File `json:"files"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+// FilesURL returns the URL of the files collection, or of a single file when name is given
+func (c *Client) FilesURL(name string) string {
+	if name == "" {
+		return fmt.Sprintf("%s/%s/files", c.BaseURL, c.APIVersion)
+	}
+	return fmt.Sprintf("%s/%s/%s", c.BaseURL, c.APIVersion, name)
+}
+
+// UploadURL is where resumable uploads start
+func (c *Client) UploadURL() string {
+	return fmt.Sprintf("%s/upload/%s/files", c.BaseURL, c.APIVersion)
+}
+
+// UploadFile sends data with the resumable protocol in chunks. A failed chunk is
+// retried from the offset the server reports, so a flaky connection doesn't
+// restart the whole upload.
+func (c *Client) UploadFile(ctx context.Context, displayName string, mimeType string, data []byte) (*File, error) {
+	var uploadURL string
+	err := llm.Retry(ctx, c.Retry, func() error {
+		var err error
+		uploadURL, err = c.startUpload(ctx, displayName, mimeType, len(data))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	offset := 0
+	resume := false
+	for {
+		var file *File
+		err := llm.Retry(ctx, c.Retry, func() error {
+			if resume {
+				received, err := c.queryUpload(ctx, uploadURL)
+				if err != nil {
+					return err
+				}
+				offset = received
+			}
+
+			end := min(offset+UploadChunkSize, len(data))
+			command := "upload"
+			if end == len(data) {
+				command = "upload, finalize"
+			}
+
+			var response fileResponse
+			header, err := c.call(ctx, "POST", uploadURL, map[string]string{
+				"X-Goog-Upload-Command": command,
+				"X-Goog-Upload-Offset":  strconv.Itoa(offset),
+			}, data[offset:end], &response)
+			if err != nil {
+				resume = true
+				return err
+			}
+
+			resume = false
+			offset = end
+			if header.Get("X-Goog-Upload-Status") == "final" || command != "upload" {
+				file = &response.File
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if file != nil {
+			log.Debugf("Uploaded %s as %s (%s)", displayName, file.Name, file.State)
+			return file, nil
+		}
+	}
+}
+
+func (c *Client) startUpload(ctx context.Context, displayName string, mimeType string, size int) (string, error) {
+	body, err := json.Marshal(map[string]any{"file": map[string]string{"display_name": displayName}})
+	if err != nil {
+		return "", err
+	}
+
+	header, err := c.call(ctx, "POST", c.UploadURL(), map[string]string{
+		"X-Goog-Upload-Protocol":              "resumable",
+		"X-Goog-Upload-Command":               "start",
+		"X-Goog-Upload-Header-Content-Length": strconv.Itoa(size),
+		"X-Goog-Upload-Header-Content-Type":   mimeType,
+		"Content-Type":                        "application/json",
+	}, body, nil)
+	if err != nil {
+		return "", err
+	}
+
+	uploadURL := header.Get("X-Goog-Upload-URL")
+	if uploadURL == "" {
+		return "", &llm.APIError{Kind: llm.ErrServerError, Message: "upload start returned no upload URL"}
+	}
+	return uploadURL, nil
+}
+
+// queryUpload asks how many bytes of an interrupted upload arrived
+func (c *Client) queryUpload(ctx context.Context, uploadURL string) (int, error) {
+	header, err := c.call(ctx, "POST", uploadURL, map[string]string{"X-Goog-Upload-Command": "query"}, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	received, err := strconv.Atoi(header.Get("X-Goog-Upload-Size-Received"))
+	if err != nil {
+		return 0, &llm.APIError{Kind: llm.ErrServerError, Message: "upload query returned no received size"}
+	}
+	return received, nil
+}
+
+// GetFile returns the metadata of a file, name looks like "files/abc123"
+func (c *Client) GetFile(ctx context.Context, name string) (*File, error) {
+	var file File
+	err := llm.Retry(ctx, c.Retry, func() error {
+		_, err := c.call(ctx, "GET", c.FilesURL(name), nil, nil, &file)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// WaitForFile polls a file until it leaves the processing state, videos take a while
+func (c *Client) WaitForFile(ctx context.Context, name string) (*File, error) {
+	for {
+		file, err := c.GetFile(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+
+		switch file.State {
+		case FileStateActive:
+			return file, nil
+		case FileStateFailed:
+			message := "processing failed"
+			if file.Error != nil {
+				message = file.Error.Message
+			}
+			return nil, &llm.APIError{Kind: llm.ErrBadRequest, Message: fmt.Sprintf("file %s: %s", name, message)}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(FilePollInterval):
+		}
+	}
+}
+
+func (c *Client) DeleteFile(ctx context.Context, name string) error {
+	return llm.Retry(ctx, c.Retry, func() error {
+		_, err := c.call(ctx, "DELETE", c.FilesURL(name), nil, nil, nil)
+		return err
+	})
+}
+
+// ListFiles returns one page of uploaded files, pass the returned token to get the next one
+func (c *Client) ListFiles(ctx context.Context, pageSize int, pageToken string) (*ListFilesResponse, error) {
+	query := url.Values{}
+	if pageSize > 0 {
+		query.Set("pageSize", strconv.Itoa(pageSize))
+	}
+	if pageToken != "" {
+		query.Set("pageToken", pageToken)
+	}
+
+	target := c.FilesURL("")
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	var list ListFilesResponse
+	err := llm.Retry(ctx, c.Retry, func() error {
+		_, err := c.call(ctx, "GET", target, nil, nil, &list)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// call is do for the Files API: any method, raw body, custom headers and
+// access to the response headers the upload protocol is built on
+func (c *Client) call(ctx context.Context, method string, target string, headers map[string]string, body []byte, out any) (http.Header, error) {
+	request, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("x-goog-api-key", c.Token)
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+
+	response, err := c.HTTPClient.Do(request)
+	if err != nil {
+		log.Errorf("Failed to send %s request to %s: %v", method, target, err)
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		return nil, &llm.APIError{Kind: llm.ErrServerError, Message: err.Error()}
+	}
+	defer response.Body.Close()
+
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		log.Errorf("%s request to %s failed with status code %d: %s", method, target, response.StatusCode, responseBody)
+		return nil, parseError(response, responseBody)
+	}
+
+	if out != nil && len(responseBody) > 0 {
+		if err := json.Unmarshal(responseBody, out); err != nil {
+			return nil, err
+		}
+	}
+
+	return response.Header, nil
+}
@@ -0,0 +1,137 @@
+package gemini
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DHCPCD9/go-swaga-bot/configuration"
+	"github.com/DHCPCD9/go-swaga-bot/llm"
+)
+
+// uploadServer is a stand-in for the resumable upload protocol. failAt makes the
+// chunk starting at that offset arrive only half before the connection "breaks".
+type uploadServer struct {
+	mu       sync.Mutex
+	received []byte
+	failAt   int
+	failed   bool
+	queries  int
+	commands []string
+}
+
+func (u *uploadServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if r.Header.Get("x-goog-api-key") != "token" {
+		http.Error(w, `{"error":{"code":403,"message":"bad key"}}`, http.StatusForbidden)
+		return
+	}
+
+	command := r.Header.Get("X-Goog-Upload-Command")
+	u.commands = append(u.commands, command)
+	body, _ := io.ReadAll(r.Body)
+
+	switch {
+	case r.URL.Path == "/upload/v1beta/files" && command == "start":
+		if r.Header.Get("X-Goog-Upload-Protocol") != "resumable" || r.Header.Get("X-Goog-Upload-Header-Content-Type") != "video/mp4" {
+			http.Error(w, `{"error":{"code":400,"message":"bad start"}}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("X-Goog-Upload-URL", "http://"+r.Host+"/session/1")
+	case r.URL.Path == "/session/1" && command == "query":
+		u.queries++
+		w.Header().Set("X-Goog-Upload-Size-Received", strconv.Itoa(len(u.received)))
+	case r.URL.Path == "/session/1":
+		offset, _ := strconv.Atoi(r.Header.Get("X-Goog-Upload-Offset"))
+		if offset != len(u.received) {
+			http.Error(w, `{"error":{"code":400,"message":"wrong offset"}}`, http.StatusBadRequest)
+			return
+		}
+		if offset == u.failAt && !u.failed {
+			u.failed = true
+			u.received = append(u.received, body[:len(body)/2]...)
+			http.Error(w, `{"error":{"code":503,"message":"connection reset"}}`, http.StatusServiceUnavailable)
+			return
+		}
+		u.received = append(u.received, body...)
+		if command == "upload, finalize" {
+			w.Header().Set("X-Goog-Upload-Status", "final")
+			json.NewEncoder(w).Encode(fileResponse{File: File{Name: "files/abc", URI: "https://files/abc", MimeType: "video/mp4", State: FileStateProcessing}})
+		}
+	default:
+		http.Error(w, `{"error":{"code":404,"message":"not found"}}`, http.StatusNotFound)
+	}
+}
+
+func testClient(url string) *Client {
+	client := NewClient(configuration.Gemini{BaseURL: url, Token: "token"})
+	client.Retry = llm.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	return client
+}
+
+func withChunkSize(t *testing.T, size int) {
+	previous := UploadChunkSize
+	UploadChunkSize = size
+	t.Cleanup(func() { UploadChunkSize = previous })
+}
+
+func TestUploadFile(t *testing.T) {
+	withChunkSize(t, 4)
+	upload := &uploadServer{failAt: -1}
+	server := httptest.NewServer(upload)
+	defer server.Close()
+
+	data := []byte("0123456789")
+	file, err := testClient(server.URL).UploadFile(context.Background(), "clip.mp4", "video/mp4", data)
+	if err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+
+	if file.Name != "files/abc" || file.URI != "https://files/abc" {
+		t.Errorf("unexpected file %+v", file)
+	}
+	if !bytes.Equal(upload.received, data) {
+		t.Errorf("server received %q, want %q", upload.received, data)
+	}
+	want := []string{"start", "upload", "upload", "upload, finalize"}
+	if len(upload.commands) != len(want) {
+		t.Fatalf("commands %q, want %q", upload.commands, want)
+	}
+	for i := range want {
+		if upload.commands[i] != want[i] {
+			t.Errorf("command %d is %q, want %q", i, upload.commands[i], want[i])
+		}
+	}
+}
+
+func TestUploadFileResumes(t *testing.T) {
+	withChunkSize(t, 4)
+	upload := &uploadServer{failAt: 4}
+	server := httptest.NewServer(upload)
+	defer server.Close()
+
+	data := []byte("0123456789")
+	if _, err := testClient(server.URL).UploadFile(context.Background(), "clip.mp4", "video/mp4", data); err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+
+	if !upload.failed {
+		t.Fatal("the interrupted chunk was never sent")
+	}
+	if upload.queries != 1 {
+		t.Errorf("queried the upload %d times, want 1", upload.queries)
+	}
+	//The half that arrived must not be sent again
+	if !bytes.Equal(upload.received, data) {
+		t.Errorf("server received %q, want %q", upload.received, data)
+	}
+}
@@ -0,0 +1,237 @@
+package gemini
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/DHCPCD9/go-swaga-bot/llm"
+)
+
+// Provider adapts Client to llm.Provider
+type Provider struct {
+	Client *Client
+}
+
+func NewProvider(client *Client) *Provider {
+	return &Provider{Client: client}
+}
+
+func (p *Provider) Name() string {
+	return "gemini"
+}
+
+func (p *Provider) Model() string {
+	return p.Client.Model
+}
+
+func (p *Provider) Generate(ctx context.Context, request *llm.Request) (*llm.Response, error) {
+	body := BuildBody(ToContents(request.Messages))
+	if request.System != "" {
+		body.SystemInstruction = &Contents{Parts: []Parts{{Text: request.System}}}
+	}
+	//Gemini refuses JSON output together with function calling, with tools the
+	//schema is left to the prompt
+	if request.ResponseSchema != nil && len(request.Tools) == 0 {
+		body.GenerationConfig.ResponseMimeType = "application/json"
+		body.GenerationConfig.ResponseSchema = ToSchema(request.ResponseSchema)
+	}
+	if len(request.Tools) > 0 {
+		body.Tools = ToTools(request.Tools)
+		if request.ToolChoice == llm.ToolChoiceNone {
+			body.ToolConfig = &ToolConfig{FunctionCallingConfig: FunctionCallingConfig{Mode: "NONE"}}
+		}
+	}
+
+	response, err := p.Client.GenerateContent(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+
+	model := response.ModelVersion
+	if model == "" {
+		model = p.Client.Model
+	}
+	usage := llm.Usage{
+		PromptTokens:     response.UsageMetadata.PromptTokenCount,
+		CandidatesTokens: response.UsageMetadata.CandidatesTokenCount,
+		TotalTokens:      response.UsageMetadata.TotalTokenCount,
+	}
+
+	if response.PromptFeedback.BlockReason != "" {
+		return nil, &llm.APIError{Kind: llm.ErrSafetyBlocked, Message: "prompt blocked: " + response.PromptFeedback.BlockReason, Usage: usage, Model: model}
+	}
+
+	if len(response.Candidates) == 0 {
+		return nil, &llm.APIError{Kind: llm.ErrServerError, Message: "gemini returned no candidates", Usage: usage, Model: model}
+	}
+
+	candidate := response.Candidates[0]
+	if isSafetyFinishReason(candidate.FinishReason) {
+		return nil, &llm.APIError{Kind: llm.ErrSafetyBlocked, Message: "candidate blocked: " + candidate.FinishReason, Usage: usage, Model: model}
+	}
+	var text strings.Builder
+	var calls []llm.FunctionCall
+	var parts []llm.Part
+	for _, part := range candidate.Content.Parts {
+		if part.Thought {
+			continue
+		}
+		if part.FunctionCall != nil {
+			call := llm.FunctionCall{
+				ID:        part.FunctionCall.ID,
+				Name:      part.FunctionCall.Name,
+				Args:      part.FunctionCall.Args,
+				Signature: part.ThoughtSignature,
+			}
+			calls = append(calls, call)
+			parts = append(parts, llm.Part{FunctionCall: &call})
+			continue
+		}
+		text.WriteString(part.Text)
+		parts = append(parts, llm.TextPart(part.Text))
+	}
+
+	return &llm.Response{
+		Text:          text.String(),
+		FinishReason:  candidate.FinishReason,
+		Model:         model,
+		FunctionCalls: calls,
+		Parts:         parts,
+		Usage:         usage,
+	}, nil
+}
+
+func (p *Provider) CountTokens(ctx context.Context, request *llm.Request) (int, error) {
+	var body any = &CountTokensBody{Contents: ToContents(request.Messages)}
+	if request.System != "" {
+		fullBody := BuildBody(ToContents(request.Messages))
+		fullBody.SystemInstruction = &Contents{Parts: []Parts{{Text: request.System}}}
+		body = &CountTokensFullBody{GenerateContentRequest: &GenerateContentRequest{Model: "models/" + p.Client.Model, GeminiBody: fullBody}}
+	}
+
+	response, err := p.Client.CountTokens(ctx, body)
+	if err != nil {
+		return 0, err
+	}
+
+	return response.TotalTokens, nil
+}
+
+func (p *Provider) EmbeddingModel() string {
+	return p.Client.EmbeddingModel
+}
+
+func (p *Provider) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	body := &BatchEmbedContentsBody{Requests: make([]EmbedContentRequest, 0, len(texts))}
+	for _, text := range texts {
+		body.Requests = append(body.Requests, EmbedContentRequest{
+			Model:                "models/" + p.Client.EmbeddingModel,
+			Content:              Contents{Parts: []Parts{{Text: text}}},
+			OutputDimensionality: p.Client.Dimensions,
+		})
+	}
+
+	response, err := p.Client.BatchEmbedContents(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(response.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(response.Embeddings))
+	}
+
+	vectors := make([][]float32, 0, len(response.Embeddings))
+	for _, embedding := range response.Embeddings {
+		vectors = append(vectors, embedding.Values)
+	}
+
+	return vectors, nil
+}
+
+// mediaTypes lists what Gemini models take as inline or uploaded data
+var mediaTypes = map[string]bool{
+	"image/png": true, "image/jpeg": true, "image/webp": true, "image/heic": true, "image/heif": true,
+	"video/mp4": true, "video/mpeg": true, "video/quicktime": true, "video/x-msvideo": true, "video/x-flv": true,
+	"video/mpg": true, "video/webm": true, "video/x-ms-wmv": true, "video/3gpp": true,
+	"audio/wav": true, "audio/x-wav": true, "audio/mpeg": true, "audio/mp3": true, "audio/aiff": true,
+	"audio/aac": true, "audio/ogg": true, "audio/flac": true,
+	"application/pdf": true, "text/plain": true,
+}
+
+func (p *Provider) AcceptsMedia(mediaType string) bool {
+	return mediaTypes[mediaType]
+}
+
+// UploadFile implements llm.FileUploader, the part is usable once Gemini finished processing the file
+func (p *Provider) UploadFile(ctx context.Context, name string, mediaType string, data []byte) (llm.Part, error) {
+	file, err := p.Client.UploadFile(ctx, name, mediaType, data)
+	if err != nil {
+		return llm.Part{}, err
+	}
+
+	if file.State != FileStateActive {
+		if file, err = p.Client.WaitForFile(ctx, file.Name); err != nil {
+			return llm.Part{}, err
+		}
+	}
+
+	return llm.Part{MimeType: mediaType, FileURI: file.URI}, nil
+}
+
+func ToTools(tools []llm.Tool) []Tool {
+	declarations := make([]FunctionDeclaration, 0, len(tools))
+	for _, tool := range tools {
+		declarations = append(declarations, FunctionDeclaration{
+			Name:        tool.Name,
+			Description: tool.Description,
+			Parameters:  ToSchema(tool.Parameters),
+		})
+	}
+	return []Tool{{FunctionDeclarations: declarations}}
+}
+
+func ToContents(messages []llm.Message) []Contents {
+	contents := make([]Contents, 0, len(messages))
+
+	for _, message := range messages {
+		content := Contents{Role: string(message.Role), Parts: make([]Parts, 0, len(message.Parts))}
+		for _, part := range message.Parts {
+			if part.Data != nil {
+				content.Parts = append(content.Parts, Parts{InlineData: &InlineData{
+					MimeType: part.MimeType,
+					Data:     base64.StdEncoding.EncodeToString(part.Data),
+				}})
+				continue
+			}
+			if part.FunctionCall != nil {
+				content.Parts = append(content.Parts, Parts{
+					FunctionCall:     &FunctionCall{ID: part.FunctionCall.ID, Name: part.FunctionCall.Name, Args: part.FunctionCall.Args},
+					ThoughtSignature: part.FunctionCall.Signature,
+				})
+				continue
+			}
+			if part.FunctionResponse != nil {
+				content.Parts = append(content.Parts, Parts{FunctionResponse: &FunctionResponse{
+					ID:       part.FunctionResponse.ID,
+					Name:     part.FunctionResponse.Name,
+					Response: part.FunctionResponse.Response,
+				}})
+				continue
+			}
+			if part.FileURI != "" {
+				content.Parts = append(content.Parts, Parts{FileData: &FileData{MimeType: part.MimeType, FileURI: part.FileURI}})
+				continue
+			}
+			//Gemini refuses empty parts
+			if part.Text == "" {
+				continue
+			}
+			content.Parts = append(content.Parts, Parts{Text: part.Text})
+		}
+		contents = append(contents, content)
+	}
+
+	return contents
+}
@@ -0,0 +1,65 @@
+package gemini
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DHCPCD9/go-swaga-bot/llm"
+)
+
+func TestGenerate(t *testing.T) {
+	var body GeminiBody
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1beta/models/"+DefaultModel+":generateContent" {
+			http.Error(w, `{"error":{"code":404,"message":"not found"}}`, http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("x-goog-api-key") != "token" {
+			http.Error(w, `{"error":{"code":403,"message":"bad key"}}`, http.StatusForbidden)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, `{"error":{"code":400,"message":"bad body"}}`, http.StatusBadRequest)
+			return
+		}
+
+		json.NewEncoder(w).Encode(GeminiResponse{
+			Candidates:    []Candidates{{Content: Content{Role: "model", Parts: []Parts{{Text: `{"response":"привет"}`}}}, FinishReason: "STOP"}},
+			UsageMetadata: UsageMetadata{PromptTokenCount: 12, CandidatesTokenCount: 5, TotalTokenCount: 17},
+			ModelVersion:  "gemini-2.5-flash-001",
+		})
+	}))
+	defer server.Close()
+
+	provider := NewProvider(testClient(server.URL))
+	response, err := provider.Generate(context.Background(), &llm.Request{
+		System:   "persona",
+		Messages: []llm.Message{llm.UserMessage(llm.TextPart("hi"))},
+		ResponseSchema: llm.SchemaFor(struct {
+			Response string `json:"response"`
+		}{}),
+	})
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+
+	if body.SystemInstruction == nil || body.SystemInstruction.Parts[0].Text != "persona" {
+		t.Errorf("system instruction not sent: %+v", body.SystemInstruction)
+	}
+	if len(body.Contents) != 1 || body.Contents[0].Role != "user" || body.Contents[0].Parts[0].Text != "hi" {
+		t.Errorf("unexpected contents %+v", body.Contents)
+	}
+	if body.GenerationConfig.ResponseMimeType != "application/json" || body.GenerationConfig.ResponseSchema == nil {
+		t.Errorf("response schema not sent: %+v", body.GenerationConfig)
+	}
+
+	if response.Text != `{"response":"привет"}` || response.FinishReason != "STOP" || response.Model != "gemini-2.5-flash-001" {
+		t.Errorf("unexpected response %+v", response)
+	}
+	if response.Usage != (llm.Usage{PromptTokens: 12, CandidatesTokens: 5, TotalTokens: 17}) {
+		t.Errorf("unexpected usage %+v", response.Usage)
+	}
+}
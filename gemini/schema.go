@@ -0,0 +1,47 @@
+package gemini
+
+import (
+	"strings"
+
+	"github.com/DHCPCD9/go-swaga-bot/llm"
+)
+
+// Schema is the OpenAPI subset accepted by responseSchema
+type Schema struct {
+	Type             string             `json:"type"`
+	Description      string             `json:"description,omitempty"`
+	Properties       map[string]*Schema `json:"properties,omitempty"`
+	PropertyOrdering []string           `json:"propertyOrdering,omitempty"`
+	Required         []string           `json:"required,omitempty"`
+	Items            *Schema            `json:"items,omitempty"`
+	Enum             []string           `json:"enum,omitempty"`
+	Format           string             `json:"format,omitempty"`
+}
+
+func ToSchema(schema *llm.Schema) *Schema {
+	if schema == nil {
+		return nil
+	}
+
+	converted := &Schema{
+		Type:             strings.ToUpper(schema.Type),
+		Description:      schema.Description,
+		PropertyOrdering: schema.PropertyOrder,
+		Required:         schema.Required,
+		Items:            ToSchema(schema.Items),
+		Enum:             schema.Enum,
+	}
+
+	if len(schema.Enum) > 0 {
+		converted.Format = "enum"
+	}
+
+	if len(schema.Properties) > 0 {
+		converted.Properties = make(map[string]*Schema, len(schema.Properties))
+		for name, property := range schema.Properties {
+			converted.Properties[name] = ToSchema(property)
+		}
+	}
+
+	return converted
+}
@@ -0,0 +1,75 @@
+package llm
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Error kinds, compare with errors.Is
+var (
+	ErrRateLimited    = errors.New("rate limited")
+	ErrQuotaExhausted = errors.New("quota exhausted")
+	ErrSafetyBlocked  = errors.New("blocked by safety filters")
+	ErrBadRequest     = errors.New("bad request")
+	ErrServerError    = errors.New("server error")
+)
+
+// APIError is returned by providers for every failed call that reached the API
+type APIError struct {
+	Kind       error
+	StatusCode int
+	RetryAfter time.Duration
+	Message    string
+	// Usage of a call that failed after the model ran, e.g. a blocked answer
+	Usage Usage
+	Model string
+}
+
+func (e *APIError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("%v (status %d): %s", e.Kind, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("%v: %s", e.Kind, e.Message)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Kind
+}
+
+func (e *APIError) Retryable() bool {
+	return e.Kind == ErrRateLimited || e.Kind == ErrServerError
+}
+
+// KindFromStatus maps an HTTP status code to one of the error kinds
+func KindFromStatus(status int) error {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case status >= 500:
+		return ErrServerError
+	default:
+		return ErrBadRequest
+	}
+}
+
+// ParseRetryAfter understands both forms of the Retry-After header: seconds and HTTP date
+func ParseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(header); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay
+		}
+	}
+
+	return 0
+}
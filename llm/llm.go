@@ -0,0 +1,141 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+type Role string
+
+const (
+	RoleUser  Role = "user"
+	RoleModel Role = "model"
+)
+
+// Part is a single piece of a message: text, inline binary data, a file
+// uploaded to the provider beforehand, or a tool call and its result
+type Part struct {
+	Text             string
+	MimeType         string
+	Data             []byte
+	FileURI          string
+	FunctionCall     *FunctionCall
+	FunctionResponse *FunctionResponse
+}
+
+// Tool is a function the model may call instead of answering right away
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  *Schema
+}
+
+// FunctionCall is a tool call made by the model. ID links the response to it,
+// Signature is opaque provider state that has to be sent back unchanged.
+type FunctionCall struct {
+	ID        string
+	Name      string
+	Args      json.RawMessage
+	Signature string
+}
+
+type FunctionResponse struct {
+	ID       string
+	Name     string
+	Response map[string]any
+}
+
+const (
+	ToolChoiceAuto = ""
+	// ToolChoiceNone makes the model answer with text even though tools were declared
+	ToolChoiceNone = "none"
+)
+
+type Message struct {
+	Role  Role
+	Parts []Part
+}
+
+type Request struct {
+	// System holds the persona and instructions, kept apart from the conversation
+	System   string
+	Messages []Message
+	// ResponseSchema asks the model to answer with JSON matching it
+	ResponseSchema *Schema
+	Tools          []Tool
+	ToolChoice     string
+}
+
+type Usage struct {
+	PromptTokens     int
+	CandidatesTokens int
+	TotalTokens      int
+}
+
+type Response struct {
+	Text          string
+	FinishReason  string
+	Model         string
+	Usage         Usage
+	FunctionCalls []FunctionCall
+	// Parts is the whole model turn, to be sent back when answering tool calls
+	Parts []Part
+}
+
+// Provider is a backend able to answer prompts. Gemini is the default one,
+// anything speaking the OpenAI chat-completions protocol can be used as well.
+type Provider interface {
+	Name() string
+	Model() string
+	Generate(ctx context.Context, request *Request) (*Response, error)
+	CountTokens(ctx context.Context, request *Request) (int, error)
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+var ErrNotSupported = errors.New("operation not supported by provider")
+
+var Default Provider
+
+func TextPart(text string) Part {
+	return Part{Text: text}
+}
+
+func UserMessage(parts ...Part) Message {
+	return Message{Role: RoleUser, Parts: parts}
+}
+
+// MediaSupport is implemented by providers that know which files their model understands
+type MediaSupport interface {
+	AcceptsMedia(mediaType string) bool
+}
+
+// FileUploader is implemented by providers able to take files too big to be sent inline.
+// The returned part references the uploaded file and can be used in any message.
+type FileUploader interface {
+	UploadFile(ctx context.Context, name string, mediaType string, data []byte) (Part, error)
+}
+
+// EmbeddingModeler is implemented by providers embedding with a different model than they generate with
+type EmbeddingModeler interface {
+	EmbeddingModel() string
+}
+
+// EmbeddingModel names the model behind provider.Embed, stored vectors are only comparable within one model
+func EmbeddingModel(provider Provider) string {
+	model := provider.Model()
+	if embedder, ok := provider.(EmbeddingModeler); ok {
+		model = embedder.EmbeddingModel()
+	}
+	return provider.Name() + "/" + model
+}
+
+// AcceptsMedia asks the provider whether it understands files of mediaType,
+// providers that don't say are assumed to take images only
+func AcceptsMedia(provider Provider, mediaType string) bool {
+	if media, ok := provider.(MediaSupport); ok {
+		return media.AcceptsMedia(mediaType)
+	}
+	return strings.HasPrefix(mediaType, "image/")
+}
@@ -0,0 +1,90 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/DHCPCD9/go-swaga-bot/configuration"
+	log "github.com/sirupsen/logrus"
+)
+
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   time.Second,
+	MaxDelay:    30 * time.Second,
+}
+
+func RetryPolicyFromConfig(config configuration.Retry) RetryPolicy {
+	policy := RetryPolicy{
+		MaxAttempts: config.MaxAttempts,
+		BaseDelay:   config.BaseDelay,
+		MaxDelay:    config.MaxDelay,
+	}
+
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if policy.BaseDelay <= 0 {
+		policy.BaseDelay = DefaultRetryPolicy.BaseDelay
+	}
+	if policy.MaxDelay <= 0 {
+		policy.MaxDelay = DefaultRetryPolicy.MaxDelay
+	}
+
+	return policy
+}
+
+// Backoff returns the exponential delay with jitter before the next attempt
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := p.BaseDelay << (attempt - 1)
+	if delay <= 0 || delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	//Up to 25% of jitter, so parallel requests don't hit the API at the same moment
+	return delay - time.Duration(rand.Int64N(int64(delay)/4+1))
+}
+
+// Retry calls call until it succeeds, returns a non retryable error, runs out of
+// attempts or ctx is done. Retry-After sent by the server wins over our own backoff.
+func Retry(ctx context.Context, policy RetryPolicy, call func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := call()
+		if err == nil {
+			return nil
+		}
+
+		var apiError *APIError
+		if !errors.As(err, &apiError) || !apiError.Retryable() || attempt >= policy.MaxAttempts {
+			return err
+		}
+
+		delay := policy.Backoff(attempt)
+		if apiError.RetryAfter > delay {
+			delay = apiError.RetryAfter
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			log.Warnf("Not retrying after %v, request deadline is too close", err)
+			return err
+		}
+
+		log.Warnf("Attempt %d/%d failed: %v, retrying in %s", attempt, policy.MaxAttempts, err, delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package llm
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Schema describes the JSON a model must answer with. It is a small subset of
+// JSON Schema that every provider understands.
+type Schema struct {
+	Type          string
+	Description   string
+	Properties    map[string]*Schema
+	PropertyOrder []string
+	Required      []string
+	Items         *Schema
+	Enum          []string
+}
+
+// SchemaFor builds a Schema from a Go value using its json tags. Fields can be
+// restricted with `enum:"a,b"` and documented with `description:"..."`.
+// Fields without omitempty are required.
+func SchemaFor(value any) *Schema {
+	return schemaForType(reflect.TypeOf(value))
+}
+
+func schemaForType(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: schemaForType(t.Elem())}
+	case reflect.Struct:
+		schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+
+			name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+
+			property := schemaForType(field.Type)
+			property.Description = field.Tag.Get("description")
+			if enum := field.Tag.Get("enum"); enum != "" {
+				property.Enum = strings.Split(enum, ",")
+			}
+
+			schema.Properties[name] = property
+			schema.PropertyOrder = append(schema.PropertyOrder, name)
+			if !strings.Contains(options, "omitempty") {
+				schema.Required = append(schema.Required, name)
+			}
+		}
+		return schema
+	default:
+		return &Schema{Type: "string"}
+	}
+}
@@ -0,0 +1,83 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"unicode"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Tokens a single image costs on most models, no matter its size
+const ImageTokens = 258
+
+// EstimateTokens guesses the token count of text without asking the provider.
+// English averages about 4 characters per token, Cyrillic and other scripts
+// are split much finer, punctuation is often a token on its own.
+func EstimateTokens(text string) int {
+	var ascii, other, punctuation int
+	for _, r := range text {
+		switch {
+		case r <= unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r)):
+			ascii++
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			punctuation++
+		default:
+			other++
+		}
+	}
+
+	return ascii/4 + other*2/5 + punctuation/2 + 1
+}
+
+// EstimatePart guesses what a part costs: text is estimated, images have a
+// fixed price and other media is priced by size, roughly a token per kilobyte
+func EstimatePart(part Part) int {
+	switch {
+	case part.FunctionCall != nil:
+		return EstimateTokens(part.FunctionCall.Name+string(part.FunctionCall.Args)) + 1
+	case part.FunctionResponse != nil:
+		response, _ := json.Marshal(part.FunctionResponse.Response)
+		return EstimateTokens(part.FunctionResponse.Name+string(response)) + 1
+	case part.Data == nil && part.FileURI == "":
+		return EstimateTokens(part.Text)
+	case strings.HasPrefix(part.MimeType, "image/"):
+		return ImageTokens
+	default:
+		return ImageTokens + len(part.Data)/1024
+	}
+}
+
+// EstimateParts sums EstimatePart over parts
+func EstimateParts(parts []Part) int {
+	total := 0
+	for _, part := range parts {
+		total += EstimatePart(part)
+	}
+	return total
+}
+
+// EstimateRequest guesses the prompt size of a whole request
+func EstimateRequest(request *Request) int {
+	total := EstimateTokens(request.System)
+	for _, message := range request.Messages {
+		total += EstimateParts(message.Parts)
+	}
+	return total
+}
+
+// CountTokens asks the provider for the exact prompt size and falls back to
+// EstimateRequest for providers that can't count, or when counting fails
+func CountTokens(ctx context.Context, provider Provider, request *Request) int {
+	count, err := provider.CountTokens(ctx, request)
+	if err == nil {
+		return count
+	}
+
+	if !errors.Is(err, ErrNotSupported) {
+		log.Warnf("Failed to count tokens with %s, using an estimate: %v", provider.Name(), err)
+	}
+	return EstimateRequest(request)
+}
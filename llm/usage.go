@@ -0,0 +1,23 @@
+package llm
+
+import (
+	"strings"
+
+	"github.com/DHCPCD9/go-swaga-bot/configuration"
+)
+
+// Cost prices usage in USD. Models are looked up exactly first, then by the
+// longest configured prefix, so "gemini-2.5-flash-001" uses the "gemini-2.5-flash" price.
+func Cost(prices map[string]configuration.Price, model string, usage Usage) float64 {
+	price, ok := prices[model]
+	if !ok {
+		longest := 0
+		for name, candidate := range prices {
+			if strings.HasPrefix(model, name) && len(name) > longest {
+				price, longest = candidate, len(name)
+			}
+		}
+	}
+
+	return (float64(usage.PromptTokens)*price.Input + float64(usage.CandidatesTokens)*price.Output) / 1_000_000
+}
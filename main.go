@@ -1,11 +1,22 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/DHCPCD9/go-swaga-bot/configuration"
 	"github.com/DHCPCD9/go-swaga-bot/database"
 	"github.com/DHCPCD9/go-swaga-bot/discord"
+	"github.com/DHCPCD9/go-swaga-bot/embeddings"
+	"github.com/DHCPCD9/go-swaga-bot/gemini"
+	"github.com/DHCPCD9/go-swaga-bot/llm"
+	"github.com/DHCPCD9/go-swaga-bot/openai"
 	"github.com/sirupsen/logrus"
 )
 
@@ -24,11 +35,100 @@ func main() {
 		logrus.Fatalf("Failed to initialize database: %v", err)
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "backfill" {
+		runBackfill(os.Args[2:])
+		return
+	}
+
+	if err := initProvider(); err != nil {
+		logrus.Fatalf("Failed to initialize LLM provider: %v", err)
+	}
+
 	if err := discord.Init(); err != nil {
 		logrus.Fatalf("Failed to initialize Discord: %v", err)
 	}
 
+	embeddings.Start(context.Background())
+	go pruneFacts()
+
 	var wg sync.WaitGroup
 	wg.Add(1)
 	wg.Wait()
 }
+
+func initProvider() error {
+	switch configuration.Config.LLM.Provider {
+	case "", "gemini":
+		if err := gemini.Init(); err != nil {
+			return err
+		}
+		llm.Default = gemini.NewProvider(gemini.DefaultClient)
+	case "openai":
+		client := openai.NewClient(configuration.Config.OpenAI)
+		client.Retry = llm.RetryPolicyFromConfig(configuration.Config.LLM.Retry)
+		client.Dimensions = configuration.Config.Embeddings.Dimensions
+		llm.Default = openai.NewProvider(client)
+	default:
+		return fmt.Errorf("unknown llm provider %q", configuration.Config.LLM.Provider)
+	}
+
+	logrus.Infof("Using %s provider with model %s", llm.Default.Name(), llm.Default.Model())
+	return nil
+}
+
+// pruneFacts forgets expired and stale facts every prune-interval
+func pruneFacts() {
+	config := configuration.Config.Memory
+	if config.PruneInterval <= 0 {
+		return
+	}
+
+	for ; ; time.Sleep(config.PruneInterval) {
+		pruned, err := database.PruneFacts(config.FactMaxAge)
+		if err != nil {
+			logrus.Errorf("Failed to prune facts: %v", err)
+		} else if pruned > 0 {
+			logrus.Infof("Forgot %d expired or stale facts", pruned)
+		}
+	}
+}
+
+// runBackfill implements "bot backfill", importing channel history without starting the bot.
+// Interrupting it is safe, the next run continues from the last saved page.
+func runBackfill(args []string) {
+	flags := flag.NewFlagSet("backfill", flag.ExitOnError)
+	guilds := flags.String("guilds", "", "comma separated guild IDs, every indexed text channel of them is imported")
+	channels := flags.String("channels", "", "comma separated channel IDs")
+	restart := flags.Bool("restart", false, "ignore checkpoints and start from the newest message")
+	limit := flags.Int("limit", 0, "maximum pages of 100 messages per channel, 0 for no limit")
+	flags.Parse(args)
+
+	options := discord.BackfillOptions{
+		GuildIDs:   splitIDs(*guilds),
+		ChannelIDs: splitIDs(*channels),
+		Restart:    *restart,
+		Limit:      *limit,
+	}
+	if len(options.GuildIDs) == 0 && len(options.ChannelIDs) == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := discord.RunBackfill(ctx, options); err != nil {
+		logrus.Fatalf("Backfill failed: %v", err)
+	}
+	logrus.Info("Backfill finished")
+}
+
+func splitIDs(value string) []string {
+	var ids []string
+	for _, id := range strings.Split(value, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,118 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/DHCPCD9/go-swaga-bot/configuration"
+	"github.com/DHCPCD9/go-swaga-bot/llm"
+	log "github.com/sirupsen/logrus"
+)
+
+const DefaultBaseURL = "http://localhost:11434/v1"
+
+// Client talks to any server implementing the OpenAI chat-completions API
+// (OpenAI itself, llama.cpp server, Ollama, vLLM...)
+type Client struct {
+	BaseURL        string
+	Model          string
+	EmbeddingModel string
+	Dimensions     int // of embeddings, 0 for the model default
+	Token          string
+	HTTPClient     *http.Client
+	Retry          llm.RetryPolicy
+}
+
+func NewClient(config configuration.OpenAI) *Client {
+	client := &Client{
+		BaseURL:        strings.TrimRight(config.BaseURL, "/"),
+		Model:          config.Model,
+		EmbeddingModel: config.EmbeddingModel,
+		Token:          config.Token,
+		HTTPClient:     http.DefaultClient,
+		Retry:          llm.DefaultRetryPolicy,
+	}
+
+	if client.BaseURL == "" {
+		client.BaseURL = DefaultBaseURL
+	}
+	if client.EmbeddingModel == "" {
+		client.EmbeddingModel = client.Model
+	}
+
+	return client
+}
+
+func (c *Client) ChatCompletion(ctx context.Context, body *ChatCompletionBody) (*ChatCompletionResponse, error) {
+	var completion ChatCompletionResponse
+	if err := c.post(ctx, c.BaseURL+"/chat/completions", body, &completion); err != nil {
+		return nil, err
+	}
+
+	return &completion, nil
+}
+
+func (c *Client) Embeddings(ctx context.Context, body *EmbeddingsBody) (*EmbeddingsResponse, error) {
+	var embeddings EmbeddingsResponse
+	if err := c.post(ctx, c.BaseURL+"/embeddings", body, &embeddings); err != nil {
+		return nil, err
+	}
+
+	return &embeddings, nil
+}
+
+func (c *Client) post(ctx context.Context, url string, body any, out any) error {
+	bodyData, err := json.Marshal(body)
+	if err != nil {
+		log.Errorf("Failed to marshal request: %v", err)
+		return err
+	}
+
+	return llm.Retry(ctx, c.Retry, func() error {
+		return c.do(ctx, url, bodyData, out)
+	})
+}
+
+func (c *Client) do(ctx context.Context, url string, bodyData []byte, out any) error {
+	request, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(bodyData))
+	if err != nil {
+		log.Errorf("Failed to create request: %v", err)
+		return err
+	}
+	if c.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := c.HTTPClient.Do(request)
+	if err != nil {
+		log.Errorf("Failed to send request: %v", err)
+		if ctx.Err() != nil {
+			return err
+		}
+		return &llm.APIError{Kind: llm.ErrServerError, Message: err.Error()}
+	}
+	defer response.Body.Close()
+
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		log.Errorf("Failed to read response body: %v", err)
+		return err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		log.Errorf("Request failed with status code %d: %s", response.StatusCode, responseBody)
+		return parseError(response, responseBody)
+	}
+
+	if err := json.Unmarshal(responseBody, out); err != nil {
+		log.Errorf("Failed to unmarshal response: %v", err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,38 @@
+package openai
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/DHCPCD9/go-swaga-bot/llm"
+)
+
+type ErrorResponse struct {
+	Error struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+		Code    any    `json:"code"`
+	} `json:"error"`
+}
+
+// parseError turns a non 200 response into llm.APIError
+func parseError(response *http.Response, body []byte) *llm.APIError {
+	apiError := &llm.APIError{
+		Kind:       llm.KindFromStatus(response.StatusCode),
+		StatusCode: response.StatusCode,
+		RetryAfter: llm.ParseRetryAfter(response.Header.Get("Retry-After")),
+		Message:    string(body),
+	}
+
+	var parsed ErrorResponse
+	if err := json.Unmarshal(body, &parsed); err != nil || parsed.Error.Message == "" {
+		return apiError
+	}
+
+	apiError.Message = parsed.Error.Message
+	if parsed.Error.Type == "insufficient_quota" || parsed.Error.Code == "insufficient_quota" {
+		apiError.Kind = llm.ErrQuotaExhausted
+	}
+
+	return apiError
+}
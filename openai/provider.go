@@ -0,0 +1,227 @@
+package openai
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/DHCPCD9/go-swaga-bot/llm"
+)
+
+// Provider adapts Client to llm.Provider
+type Provider struct {
+	Client *Client
+}
+
+func NewProvider(client *Client) *Provider {
+	return &Provider{Client: client}
+}
+
+func (p *Provider) Name() string {
+	return "openai"
+}
+
+func (p *Provider) Model() string {
+	return p.Client.Model
+}
+
+func (p *Provider) Generate(ctx context.Context, request *llm.Request) (*llm.Response, error) {
+	body := &ChatCompletionBody{
+		Model:    p.Client.Model,
+		Messages: ToChatMessages(request.System, request.Messages),
+	}
+	if request.ResponseSchema != nil {
+		body.ResponseFormat = &ResponseFormat{
+			Type:       "json_schema",
+			JSONSchema: &JSONSchema{Name: "response", Schema: ToJSONSchema(request.ResponseSchema)},
+		}
+	}
+	for _, tool := range request.Tools {
+		body.Tools = append(body.Tools, ChatTool{Type: "function", Function: FunctionTool{
+			Name:        tool.Name,
+			Description: tool.Description,
+			Parameters:  ToJSONSchema(tool.Parameters),
+		}})
+	}
+	if len(request.Tools) > 0 && request.ToolChoice == llm.ToolChoiceNone {
+		body.ToolChoice = "none"
+	}
+
+	response, err := p.Client.ChatCompletion(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+
+	model := response.Model
+	if model == "" {
+		model = p.Client.Model
+	}
+	usage := llm.Usage{
+		PromptTokens:     response.Usage.PromptTokens,
+		CandidatesTokens: response.Usage.CompletionTokens,
+		TotalTokens:      response.Usage.TotalTokens,
+	}
+
+	if len(response.Choices) == 0 {
+		return nil, &llm.APIError{Kind: llm.ErrServerError, Message: "chat completion returned no choices", Usage: usage, Model: model}
+	}
+
+	if response.Choices[0].FinishReason == "content_filter" {
+		return nil, &llm.APIError{Kind: llm.ErrSafetyBlocked, Message: "completion blocked by content filter", Usage: usage, Model: model}
+	}
+
+	message := response.Choices[0].Message
+	var calls []llm.FunctionCall
+	var parts []llm.Part
+	if message.Content != "" {
+		parts = append(parts, llm.TextPart(message.Content))
+	}
+	for _, toolCall := range message.ToolCalls {
+		call := llm.FunctionCall{ID: toolCall.ID, Name: toolCall.Function.Name, Args: json.RawMessage(toolCall.Function.Arguments)}
+		calls = append(calls, call)
+		parts = append(parts, llm.Part{FunctionCall: &call})
+	}
+
+	return &llm.Response{
+		Text:          message.Content,
+		FinishReason:  response.Choices[0].FinishReason,
+		Model:         model,
+		FunctionCalls: calls,
+		Parts:         parts,
+		Usage:         usage,
+	}, nil
+}
+
+// CountTokens is not part of the OpenAI API
+func (p *Provider) CountTokens(ctx context.Context, request *llm.Request) (int, error) {
+	return 0, llm.ErrNotSupported
+}
+
+func (p *Provider) EmbeddingModel() string {
+	return p.Client.EmbeddingModel
+}
+
+func (p *Provider) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	response, err := p.Client.Embeddings(ctx, &EmbeddingsBody{Model: p.Client.EmbeddingModel, Input: texts, Dimensions: p.Client.Dimensions})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(response.Data) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(response.Data))
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, embedding := range response.Data {
+		if embedding.Index < 0 || embedding.Index >= len(vectors) {
+			return nil, fmt.Errorf("embedding index %d out of range", embedding.Index)
+		}
+		vectors[embedding.Index] = embedding.Embedding
+	}
+
+	return vectors, nil
+}
+
+// AcceptsMedia allows the image formats vision models take, anything else is dropped by ToChatMessages
+func (p *Provider) AcceptsMedia(mediaType string) bool {
+	switch mediaType {
+	case "image/png", "image/jpeg", "image/webp", "image/gif":
+		return true
+	}
+	return false
+}
+
+func ToChatMessages(system string, messages []llm.Message) []ChatMessage {
+	chatMessages := make([]ChatMessage, 0, len(messages)+1)
+
+	if system != "" {
+		chatMessages = append(chatMessages, ChatMessage{Role: "system", Content: system})
+	}
+
+	for _, message := range messages {
+		role := "user"
+		if message.Role == llm.RoleModel {
+			role = "assistant"
+		}
+
+		//Tool calls and their results are messages of their own here, not parts
+		var toolCalls []ToolCall
+		var rest []llm.Part
+		for _, part := range message.Parts {
+			switch {
+			case part.FunctionResponse != nil:
+				content, _ := json.Marshal(part.FunctionResponse.Response)
+				chatMessages = append(chatMessages, ChatMessage{Role: "tool", ToolCallID: part.FunctionResponse.ID, Content: string(content)})
+			case part.FunctionCall != nil:
+				toolCall := ToolCall{ID: part.FunctionCall.ID, Type: "function"}
+				toolCall.Function.Name = part.FunctionCall.Name
+				toolCall.Function.Arguments = string(part.FunctionCall.Args)
+				if toolCall.Function.Arguments == "" {
+					toolCall.Function.Arguments = "{}"
+				}
+				toolCalls = append(toolCalls, toolCall)
+			default:
+				rest = append(rest, part)
+			}
+		}
+		if len(toolCalls) > 0 {
+			var text strings.Builder
+			for _, part := range rest {
+				text.WriteString(part.Text)
+			}
+			chatMessages = append(chatMessages, ChatMessage{Role: role, Content: text.String(), ToolCalls: toolCalls})
+			continue
+		}
+		if len(rest) == 0 {
+			continue
+		}
+		message.Parts = rest
+
+		hasImages := false
+		for _, part := range message.Parts {
+			if part.Data != nil {
+				hasImages = true
+				break
+			}
+		}
+
+		//Plain string content is understood by every server, parts only where needed
+		if !hasImages {
+			var text strings.Builder
+			for i, part := range message.Parts {
+				//Files uploaded to another provider can't be referenced here
+				if part.FileURI != "" {
+					continue
+				}
+				if i > 0 {
+					text.WriteString("\n")
+				}
+				text.WriteString(part.Text)
+			}
+			chatMessages = append(chatMessages, ChatMessage{Role: role, Content: text.String()})
+			continue
+		}
+
+		parts := make([]ContentPart, 0, len(message.Parts))
+		for _, part := range message.Parts {
+			if part.FileURI != "" {
+				continue
+			}
+			if part.Data == nil {
+				parts = append(parts, ContentPart{Type: "text", Text: part.Text})
+				continue
+			}
+			if !strings.HasPrefix(part.MimeType, "image/") {
+				continue
+			}
+			parts = append(parts, ContentPart{Type: "image_url", ImageURL: &ImageURL{
+				URL: "data:" + part.MimeType + ";base64," + base64.StdEncoding.EncodeToString(part.Data),
+			}})
+		}
+		chatMessages = append(chatMessages, ChatMessage{Role: role, Content: parts})
+	}
+
+	return chatMessages
+}
@@ -0,0 +1,72 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DHCPCD9/go-swaga-bot/configuration"
+	"github.com/DHCPCD9/go-swaga-bot/llm"
+)
+
+func TestGenerate(t *testing.T) {
+	var body struct {
+		Model          string          `json:"model"`
+		Messages       []ChatMessage   `json:"messages"`
+		ResponseFormat *ResponseFormat `json:"response_format"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			http.Error(w, `{"error":{"message":"not found"}}`, http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, `{"error":{"message":"bad key"}}`, http.StatusUnauthorized)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, `{"error":{"message":"bad body"}}`, http.StatusBadRequest)
+			return
+		}
+
+		w.Write([]byte(`{
+			"model": "llama3.1:8b",
+			"choices": [{"index": 0, "message": {"role": "assistant", "content": "{\"response\":\"привет\"}"}, "finish_reason": "stop"}],
+			"usage": {"prompt_tokens": 12, "completion_tokens": 5, "total_tokens": 17}
+		}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(configuration.OpenAI{BaseURL: server.URL + "/v1", Model: "llama3.1", Token: "token"})
+	client.Retry = llm.RetryPolicy{MaxAttempts: 1, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	response, err := NewProvider(client).Generate(context.Background(), &llm.Request{
+		System:   "persona",
+		Messages: []llm.Message{llm.UserMessage(llm.TextPart("hi"))},
+		ResponseSchema: llm.SchemaFor(struct {
+			Response string `json:"response"`
+		}{}),
+	})
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+
+	if body.Model != "llama3.1" || len(body.Messages) != 2 {
+		t.Fatalf("unexpected request %+v", body)
+	}
+	if body.Messages[0].Role != "system" || body.Messages[0].Content != "persona" || body.Messages[1].Role != "user" || body.Messages[1].Content != "hi" {
+		t.Errorf("unexpected messages %+v", body.Messages)
+	}
+	if body.ResponseFormat == nil || body.ResponseFormat.Type != "json_schema" {
+		t.Errorf("response schema not sent: %+v", body.ResponseFormat)
+	}
+
+	if response.Text != `{"response":"привет"}` || response.FinishReason != "stop" || response.Model != "llama3.1:8b" {
+		t.Errorf("unexpected response %+v", response)
+	}
+	if response.Usage != (llm.Usage{PromptTokens: 12, CandidatesTokens: 5, TotalTokens: 17}) {
+		t.Errorf("unexpected usage %+v", response.Usage)
+	}
+}
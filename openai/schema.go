@@ -0,0 +1,43 @@
+package openai
+
+import "github.com/DHCPCD9/go-swaga-bot/llm"
+
+type ResponseFormat struct {
+	Type       string      `json:"type"`
+	JSONSchema *JSONSchema `json:"json_schema,omitempty"`
+}
+
+type JSONSchema struct {
+	Name   string `json:"name"`
+	Schema any    `json:"schema"`
+}
+
+// ToJSONSchema converts llm.Schema to a plain JSON Schema document
+func ToJSONSchema(schema *llm.Schema) map[string]any {
+	if schema == nil {
+		return nil
+	}
+
+	converted := map[string]any{"type": schema.Type}
+	if schema.Description != "" {
+		converted["description"] = schema.Description
+	}
+	if len(schema.Enum) > 0 {
+		converted["enum"] = schema.Enum
+	}
+	if schema.Items != nil {
+		converted["items"] = ToJSONSchema(schema.Items)
+	}
+	if schema.Type == "object" {
+		properties := make(map[string]any, len(schema.Properties))
+		for name, property := range schema.Properties {
+			properties[name] = ToJSONSchema(property)
+		}
+		converted["properties"] = properties
+		if len(schema.Required) > 0 {
+			converted["required"] = schema.Required
+		}
+	}
+
+	return converted
+}
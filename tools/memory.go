@@ -0,0 +1,182 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DHCPCD9/go-swaga-bot/database"
+)
+
+type factArgs struct {
+	UserID string `json:"user_id" description:"ID пользователя"`
+	Fact   string `json:"fact" description:"Факт одним коротким предложением"`
+}
+
+type rememberArgs struct {
+	UserID        string  `json:"user_id" description:"ID пользователя"`
+	Fact          string  `json:"fact" description:"Факт одним коротким предложением"`
+	Confidence    float64 `json:"confidence,omitempty" description:"Насколько ты уверена в факте, от 0 до 1"`
+	ExpiresInDays int     `json:"expires_in_days,omitempty" description:"Через сколько дней факт станет неактуальным, если он временный"`
+}
+
+type nicknameArgs struct {
+	UserID   string `json:"user_id" description:"ID пользователя"`
+	Nickname string `json:"nickname" description:"Кличка"`
+}
+
+type lookupArgs struct {
+	UserID string `json:"user_id,omitempty" description:"ID пользователя, если известен"`
+	Name   string `json:"name,omitempty" description:"Ник или кличка, если ID неизвестен"`
+}
+
+func init() {
+	register("remember_fact", "Запомнить факт о пользователе. Только то, что не будет постоянно меняться и ещё не известно. Временным фактам укажи срок.", rememberFact)
+	register("forget_fact", "Забыть факт о пользователе, текст факта должен совпадать с известным.", forgetFact)
+	register("add_nickname", "Запомнить кличку пользователя.", addNickname)
+	register("remove_nickname", "Забыть кличку пользователя.", removeNickname)
+	register("lookup_user", "Найти пользователя этого сервера по ID или нику и узнать его клички и факты о нём.", lookupUser)
+}
+
+func parseUserID(userID string) (uint64, error) {
+	id, err := strconv.ParseUint(strings.Trim(userID, "<@!> "), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a user ID", userID)
+	}
+	return id, nil
+}
+
+func rememberFact(ctx context.Context, invocation Invocation, args rememberArgs) (map[string]any, error) {
+	user, err := parseUserID(args.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(args.Fact) == "" {
+		return nil, errors.New("fact is empty")
+	}
+
+	fact := database.UserFact{
+		Fact:       strings.TrimSpace(args.Fact),
+		UserID:     user,
+		MessageID:  invocation.MessageID,
+		GuildID:    invocation.GuildID,
+		Model:      invocation.Model,
+		Confidence: min(max(args.Confidence, 0), 1),
+	}
+	if args.ExpiresInDays > 0 {
+		fact.ExpiresAt = time.Now().AddDate(0, 0, args.ExpiresInDays).Unix()
+	}
+
+	if err := database.AddFact(fact); err != nil {
+		if errors.Is(err, database.ErrOptedOut) {
+			return nil, errors.New("user asked not to remember anything about them")
+		}
+		return nil, err
+	}
+	return map[string]any{"ok": true}, nil
+}
+
+func forgetFact(ctx context.Context, invocation Invocation, args factArgs) (map[string]any, error) {
+	user, err := parseUserID(args.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	removed, err := database.RemoveFact(user, args.Fact)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"ok": true, "removed": removed}, nil
+}
+
+func addNickname(ctx context.Context, invocation Invocation, args nicknameArgs) (map[string]any, error) {
+	user, err := parseUserID(args.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(args.Nickname) == "" {
+		return nil, errors.New("nickname is empty")
+	}
+
+	if err := database.AddUsername(user, strings.TrimSpace(args.Nickname)); err != nil {
+		if errors.Is(err, database.ErrOptedOut) {
+			return nil, errors.New("user asked not to remember anything about them")
+		}
+		return nil, err
+	}
+	return map[string]any{"ok": true}, nil
+}
+
+func removeNickname(ctx context.Context, invocation Invocation, args nicknameArgs) (map[string]any, error) {
+	user, err := parseUserID(args.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	removed, err := database.RemoveUsername(user, args.Nickname)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"ok": true, "removed": removed}, nil
+}
+
+func lookupUser(ctx context.Context, invocation Invocation, args lookupArgs) (map[string]any, error) {
+	var ids []uint64
+	switch {
+	case args.UserID != "":
+		id, err := parseUserID(args.UserID)
+		if err != nil {
+			return nil, err
+		}
+		members, err := database.GuildAuthors(invocation.GuildID, []string{strconv.FormatUint(id, 10)})
+		if err != nil {
+			return nil, err
+		}
+		if members[strconv.FormatUint(id, 10)] {
+			ids = []uint64{id}
+		}
+	case args.Name != "":
+		found, err := database.FindUsers(invocation.GuildID, args.Name, 5)
+		if err != nil {
+			return nil, err
+		}
+		ids = found
+	default:
+		return nil, errors.New("either user_id or name is required")
+	}
+
+	//Only people seen on this server, in direct messages only the one talking
+	if invocation.GuildID == "" {
+		ids = slices.DeleteFunc(ids, func(id uint64) bool {
+			return strconv.FormatUint(id, 10) != invocation.UserID
+		})
+	}
+
+	users := make([]map[string]any, 0, len(ids))
+	for _, id := range ids {
+		if database.IsOptedOut(id) {
+			continue
+		}
+
+		facts, err := database.ListFacts(id)
+		if err != nil {
+			return nil, err
+		}
+		names, err := database.ListUsernames(id)
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, map[string]any{
+			"user_id":     strconv.FormatUint(id, 10),
+			"known_names": database.NamestToStrings(names),
+			"facts":       database.FactsToStrings(facts),
+		})
+	}
+
+	return map[string]any{"users": users}, nil
+}
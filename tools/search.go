@@ -0,0 +1,107 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/DHCPCD9/go-swaga-bot/database"
+)
+
+const (
+	searchDefaultLimit = 20
+	searchMaxLimit     = 50
+	searchContentRunes = 500
+)
+
+type searchArgs struct {
+	Query     string `json:"query,omitempty" description:"Слова, которые должны быть в сообщении, подходят и их начала"`
+	UserID    string `json:"user_id,omitempty" description:"ID автора сообщений"`
+	ChannelID string `json:"channel_id,omitempty" description:"ID канала"`
+	After     string `json:"after,omitempty" description:"Не раньше этой даты, YYYY-MM-DD"`
+	Before    string `json:"before,omitempty" description:"Не позже этой даты включительно, YYYY-MM-DD"`
+	Limit     int    `json:"limit,omitempty" description:"Сколько сообщений вернуть, до 50"`
+}
+
+func init() {
+	register("search_messages", "Найти старые сообщения этого сервера по словам, автору, каналу и датам. Новые идут первыми. Нужен хотя бы один фильтр.", searchMessages)
+}
+
+func searchMessages(ctx context.Context, invocation Invocation, args searchArgs) (map[string]any, error) {
+	query := database.MessageQuery{
+		GuildID:   invocation.GuildID,
+		ChannelID: args.ChannelID,
+		Text:      strings.TrimSpace(args.Query),
+		Limit:     args.Limit,
+	}
+	if query.Limit <= 0 {
+		query.Limit = searchDefaultLimit
+	}
+	query.Limit = min(query.Limit, searchMaxLimit)
+
+	//Direct messages are searched only within the current conversation
+	if invocation.GuildID == "" {
+		query.ChannelID = invocation.ChannelID
+	}
+
+	if args.UserID != "" {
+		user, err := parseUserID(args.UserID)
+		if err != nil {
+			return nil, err
+		}
+		query.AuthorID = fmt.Sprint(user)
+	}
+
+	var err error
+	if query.After, err = parseDate(args.After, 0); err != nil {
+		return nil, err
+	}
+	if query.Before, err = parseDate(args.Before, 24*time.Hour); err != nil {
+		return nil, err
+	}
+
+	if query.Text == "" && query.AuthorID == "" && args.ChannelID == "" && query.After == 0 && query.Before == 0 {
+		return nil, errors.New("at least one filter is required")
+	}
+
+	query.Visible = invocation.CanRead
+	found, err := database.SearchMessages(query)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]map[string]any, 0, len(found))
+	for _, message := range found {
+		content := []rune(message.Content)
+		if len(content) > searchContentRunes {
+			content = append(content[:searchContentRunes], '…')
+		}
+
+		messages = append(messages, map[string]any{
+			"message_id": message.MessageID,
+			"channel_id": message.ChannelID,
+			"channel":    message.ChannelName,
+			"user_id":    message.AuthorID,
+			"username":   message.Username,
+			"time":       time.Unix(message.CreatedAt, 0).UTC().Format(time.RFC3339),
+			"content":    string(content),
+		})
+	}
+
+	return map[string]any{"messages": messages}, nil
+}
+
+// parseDate reads YYYY-MM-DD as UTC, shifted by offset, zero for an empty date
+func parseDate(date string, offset time.Duration) (int64, error) {
+	if date == "" {
+		return 0, nil
+	}
+
+	parsed, err := time.Parse("2006-01-02", strings.TrimSpace(date))
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a YYYY-MM-DD date", date)
+	}
+	return parsed.Add(offset).Unix(), nil
+}
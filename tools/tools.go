@@ -0,0 +1,82 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/DHCPCD9/go-swaga-bot/llm"
+	log "github.com/sirupsen/logrus"
+)
+
+// Invocation says on whose behalf the model calls a tool
+type Invocation struct {
+	GuildID   string
+	ChannelID string
+	UserID    string
+	MessageID string // the message being answered
+	Model     string // that asked for the call
+	// CanRead reports whether the user may see a channel, nil allows every channel
+	CanRead func(channelID string) bool
+}
+
+type handler func(ctx context.Context, invocation Invocation, args json.RawMessage) (map[string]any, error)
+
+type tool struct {
+	definition llm.Tool
+	handler    handler
+}
+
+var tools = map[string]*tool{}
+var order []string
+
+// register adds a tool, Args is a zero value of the struct its arguments decode into
+func register[Args any](name string, description string, run func(ctx context.Context, invocation Invocation, args Args) (map[string]any, error)) {
+	var zero Args
+	tools[name] = &tool{
+		definition: llm.Tool{Name: name, Description: description, Parameters: llm.SchemaFor(zero)},
+		handler: func(ctx context.Context, invocation Invocation, raw json.RawMessage) (map[string]any, error) {
+			var args Args
+			if len(raw) > 0 {
+				if err := json.Unmarshal(raw, &args); err != nil {
+					return nil, fmt.Errorf("invalid arguments: %w", err)
+				}
+			}
+			return run(ctx, invocation, args)
+		},
+	}
+	order = append(order, name)
+}
+
+// Definitions returns every tool for llm.Request.Tools, always in the same order
+func Definitions() []llm.Tool {
+	definitions := make([]llm.Tool, 0, len(order))
+	for _, name := range order {
+		definitions = append(definitions, tools[name].definition)
+	}
+	return definitions
+}
+
+// Call runs a tool the model asked for. Failures are reported back to the
+// model as an error field, so it can tell the user or try differently.
+func Call(ctx context.Context, invocation Invocation, call llm.FunctionCall) *llm.FunctionResponse {
+	response := &llm.FunctionResponse{ID: call.ID, Name: call.Name}
+
+	t, ok := tools[call.Name]
+	if !ok {
+		log.Warnf("Model called unknown tool %s", call.Name)
+		response.Response = map[string]any{"error": "unknown tool " + call.Name}
+		return response
+	}
+
+	log.Infof("Calling tool %s with %s for %s", call.Name, call.Args, invocation.UserID)
+	result, err := t.handler(ctx, invocation, call.Args)
+	if err != nil {
+		log.Warnf("Tool %s failed: %v", call.Name, err)
+		response.Response = map[string]any{"error": err.Error()}
+		return response
+	}
+
+	response.Response = result
+	return response
+}